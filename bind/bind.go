@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
 )
 
 type (
@@ -17,7 +18,7 @@ func Middleware(dataFunc DataFunc) gin.HandlerFunc {
 		var err error
 		data := dataFunc(ctx)
 		if err = ctx.ShouldBind(data); err != nil {
-			ctx.AbortWithError(http.StatusBadRequest, err).SetType(gin.ErrorTypeBind)
+			ctx.AbortWithError(statusCode(err), err).SetType(gin.ErrorTypeBind)
 			return
 		}
 		ctx.Set(CONTEXT, data)
@@ -30,7 +31,7 @@ func MiddlewareQuery(dataFunc DataFunc) gin.HandlerFunc {
 		var err error
 		data := dataFunc(ctx)
 		if err = ctx.ShouldBindQuery(data); err != nil {
-			ctx.AbortWithError(http.StatusBadRequest, err).SetType(gin.ErrorTypeBind)
+			ctx.AbortWithError(statusCode(err), err).SetType(gin.ErrorTypeBind)
 			return
 		}
 		ctx.Set(CONTEXT, data)
@@ -43,10 +44,18 @@ func MiddlewareJSON(dataFunc DataFunc) gin.HandlerFunc {
 		var err error
 		data := dataFunc(ctx)
 		if err = ctx.ShouldBindJSON(data); err != nil {
-			ctx.AbortWithError(http.StatusBadRequest, err).SetType(gin.ErrorTypeBind)
+			ctx.AbortWithError(statusCode(err), err).SetType(gin.ErrorTypeBind)
 			return
 		}
 		ctx.Set(CONTEXT, data)
 		ctx.Next()
 	}
 }
+
+// 读取请求体时的 errs.Error  如 解压超过限制
+func statusCode(err error) int {
+	if e, ok := err.(*errs.Error); ok && e.StatusCode != 0 {
+		return e.StatusCode
+	}
+	return http.StatusBadRequest
+}
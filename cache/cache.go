@@ -25,14 +25,18 @@ var CONTEXT = "GIN.SERVER.CACHE"
 
 func Middleware(c Config) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		ctx.Set(CONTEXT, &Cache{
-			Control: c.Control,
-			context: ctx,
-		})
+		ctx.Set(CONTEXT, New(ctx, c.Control))
 		ctx.Next()
 	}
 }
 
+func New(ctx *gin.Context, control []string) *Cache {
+	return &Cache{
+		Control: control,
+		context: ctx,
+	}
+}
+
 func (c *Cache) Header() {
 	ctx := c.context
 	ctx.Header("cache-control", strings.Join(c.Control, ","))
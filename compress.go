@@ -2,12 +2,21 @@ package server
 
 type (
 	Compress struct {
-		Types []string `json:"types,omitempty"`
+		Types    []string `json:"types,omitempty"`
+		Excludes []string `json:"excludes,omitempty"`
 	}
 )
 
 func (config *Compress) init(server *Server, handler *Handler) {
 	if config.Types == nil {
-		config.Types = []string{"application/json", "text/plain"}
+		config.Types = []string{
+			"text/*",
+			"application/json",
+			"application/javascript",
+			"application/xml",
+			"image/svg+xml",
+			"*+json",
+			"*+xml",
+		}
 	}
 }
@@ -0,0 +1,42 @@
+//go:build cgo && !purego
+// +build cgo,!purego
+
+package compress
+
+import (
+	"io"
+
+	"github.com/google/brotli/go/cbrotli"
+)
+
+// cbrotli 没有 Reset  每个响应创建新的编码器 不放回池
+const brotliReusable = false
+
+type (
+	brotliWriter struct {
+		*cbrotli.Writer
+		options cbrotli.WriterOptions
+	}
+)
+
+func newBrotliWriter(quality int, lgwin int) *brotliWriter {
+	return &brotliWriter{
+		options: cbrotli.WriterOptions{
+			Quality: quality,
+			LGWin:   lgwin,
+		},
+	}
+}
+
+func (w *brotliWriter) Reset(dst io.Writer) {
+	w.Writer = cbrotli.NewWriter(dst, w.options)
+}
+
+func (w *brotliWriter) Close() (err error) {
+	if w.Writer == nil {
+		return
+	}
+	err = w.Writer.Close()
+	w.Writer = nil
+	return
+}
@@ -0,0 +1,34 @@
+//go:build !cgo || purego
+// +build !cgo purego
+
+package compress
+
+import (
+	"io/ioutil"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Close 后重置到 Discard  可以放回池复用
+const brotliReusable = true
+
+type (
+	brotliWriter struct {
+		*brotli.Writer
+	}
+)
+
+func newBrotliWriter(quality int, lgwin int) *brotliWriter {
+	return &brotliWriter{
+		Writer: brotli.NewWriterOptions(ioutil.Discard, brotli.WriterOptions{
+			Quality: quality,
+			LGWin:   lgwin,
+		}),
+	}
+}
+
+func (w *brotliWriter) Close() (err error) {
+	err = w.Writer.Close()
+	w.Writer.Reset(ioutil.Discard)
+	return
+}
@@ -1,22 +1,24 @@
 package compress
 
 import (
+	"bufio"
 	"compress/gzip"
 	"io"
 	"io/ioutil"
 	"mime"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/gin-gonic/gin"
-	"github.com/google/brotli/go/cbrotli"
 )
 
 type (
 	Config struct {
 		Types     []string
+		Excludes  []string
 		MinLength int64
 		BrQuality int
 		BrLGWin   int
@@ -29,9 +31,31 @@ type (
 		config   Config
 		encoding string
 		gzipPool *sync.Pool
+		brPool   *sync.Pool
+		ctx      *gin.Context
+		opened   bool
+		hijacked bool
+		closed   bool
+		size     int
+	}
+
+	// 编码器输出 连接被接管后丢弃
+	encoderOutput struct {
+		*compressWriter
+	}
+
+	encoder interface {
+		io.WriteCloser
+		Flush() error
 	}
 )
 
+var CONTEXT = "GIN.SERVER.COMPRESS"
+var CONTEXT_OVERRIDE = "GIN.SERVER.COMPRESS.OVERRIDE"
+
+// 服务端支持的编码 按优先级排序
+var encodings = []string{"br", "gzip"}
+
 func Middleware(config Config) gin.HandlerFunc {
 	gzipPool := &sync.Pool{
 		New: func() interface{} {
@@ -42,9 +66,15 @@ func Middleware(config Config) gin.HandlerFunc {
 			return writer
 		},
 	}
+	// cgo 构建的 cbrotli 不能重置  只有纯 Go 构建复用编码器
+	brPool := &sync.Pool{
+		New: func() interface{} {
+			return newBrotliWriter(config.BrQuality, config.BrLGWin)
+		},
+	}
 
 	return func(ctx *gin.Context) {
-		encoding := getEncoding(ctx.Request)
+		encoding, ok := getEncoding(ctx.Request)
 		vary := ctx.Writer.Header().Get("Vary")
 		if vary == "" {
 			vary = "Accept-Encoding"
@@ -52,11 +82,20 @@ func Middleware(config Config) gin.HandlerFunc {
 			vary += ", Accept-Encoding"
 		}
 		ctx.Header("Vary", vary)
+
+		// 无可接受的编码
+		if !ok {
+			ctx.AbortWithStatus(http.StatusNotAcceptable)
+			return
+		}
+
 		// 没有编码
 		if encoding == "" {
+			ctx.Set(CONTEXT, "identity")
 			ctx.Next()
 			return
 		}
+		ctx.Set(CONTEXT, encoding)
 
 		writer := &compressWriter{
 			ResponseWriter: ctx.Writer,
@@ -65,14 +104,46 @@ func Middleware(config Config) gin.HandlerFunc {
 			config:         config,
 			encoding:       encoding,
 			gzipPool:       gzipPool,
+			brPool:         brPool,
+			ctx:            ctx,
 		}
 		ctx.Writer = writer
-		defer writer.close()
+		defer writer.Close()
+		ctx.Next()
+	}
+}
+
+// 路由级别 强制压缩或禁用压缩
+func Override(enable bool) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Set(CONTEXT_OVERRIDE, enable)
 		ctx.Next()
 	}
 }
 
-func getEncoding(req *http.Request) (encoding string) {
+// 类型匹配  支持 text/html  text/*  */*  *+json
+func MatchType(patterns []string, mediatype string) bool {
+	mediatype = strings.ToLower(mediatype)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		switch {
+		case pattern == mediatype, pattern == "*", pattern == "*/*":
+			return true
+		case strings.HasSuffix(pattern, "/*"):
+			if strings.HasPrefix(mediatype, pattern[:len(pattern)-1]) {
+				return true
+			}
+		case strings.HasPrefix(pattern, "*+"):
+			if strings.HasSuffix(mediatype, pattern[1:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func getEncoding(req *http.Request) (encoding string, ok bool) {
+	ok = true
 	if req.Method == http.MethodOptions {
 		return
 	}
@@ -82,66 +153,191 @@ func getEncoding(req *http.Request) (encoding string) {
 	if strings.Contains(req.Header.Get("Connection"), "Upgrade") {
 		return
 	}
+	return Negotiate(req.Header, encodings)
+}
 
-	for _, val := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
-		val = strings.TrimSpace(val)
-		if val == "br" {
-			encoding = val
-			break
+// 按 Accept-Encoding 从 available 中选择编码  available 按服务端优先级排序
+// encoding 为空表示 identity  ok 为 false 表示没有可接受的编码
+func Negotiate(header http.Header, available []string) (encoding string, ok bool) {
+	ok = true
+	values, exists := header["Accept-Encoding"]
+	if !exists {
+		return
+	}
+
+	qvalues := parseAcceptEncoding(strings.Join(values, ","))
+
+	wildcard, hasWildcard := qvalues["*"]
+
+	// identity 未列出时 未被 *;q=0 拒绝就可接受  优先级最低 只在没有可接受的编码时使用
+	identity, hasIdentity := qvalues["identity"]
+	acceptable := identity > 0
+	if !hasIdentity {
+		acceptable = !hasWildcard || wildcard > 0
+	}
+
+	var best float64
+	for _, val := range available {
+		q, has := qvalues[val]
+		if !has {
+			if !hasWildcard {
+				continue
+			}
+			q = wildcard
 		}
-		if val == "gzip" {
+		if q > best {
+			best = q
 			encoding = val
 		}
 	}
+
+	if encoding != "" && best >= identity {
+		return
+	}
+	encoding = ""
+	ok = acceptable
+	return
+}
+
+// Accept-Encoding 解析  coding -> qvalue
+func parseAcceptEncoding(header string) (qvalues map[string]float64) {
+	qvalues = map[string]float64{}
+	for _, val := range strings.Split(header, ",") {
+		var coding string
+		var params string
+		if index := strings.Index(val, ";"); index != -1 {
+			coding = val[:index]
+			params = val[index+1:]
+		} else {
+			coding = val
+		}
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+
+		q := 1.0
+		valid := true
+		for _, param := range strings.Split(params, ";") {
+			param = strings.TrimSpace(param)
+			if len(param) < 2 || (param[0] != 'q' && param[0] != 'Q') || param[1] != '=' {
+				continue
+			}
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(param[2:]), 64); err != nil || q < 0 || q > 1 {
+				valid = false
+			}
+		}
+		if !valid {
+			continue
+		}
+
+		// 重复时取最大
+		if prev, ok := qvalues[coding]; !ok || q > prev {
+			qvalues[coding] = q
+		}
+	}
 	return
 }
 
 func (w *compressWriter) WriteString(data string) (int, error) {
-	return w.writer.Write([]byte(data))
+	return w.Write([]byte(data))
 }
 
 func (w *compressWriter) Write(data []byte) (int, error) {
-	if !w.Written() {
+	if !w.opened {
 		w.open(int64(len(data)))
 	}
-	return w.writer.Write(data)
+	n, err := w.writer.Write(data)
+	w.size += n
+	return n, err
+}
+
+// 压缩前的字节数
+func (w *compressWriter) UncompressedSize() int {
+	return w.size
 }
 
 func (w *compressWriter) WriteHeader(code int) {
 	w.ResponseWriter.WriteHeader(code)
 }
 
+func (w *compressWriter) Flush() {
+	if w.hijacked {
+		return
+	}
+	// 流式响应 长度未知
+	if !w.opened {
+		w.open(-1)
+	}
+	if writer, ok := w.writer.(encoder); ok {
+		writer.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := w.ResponseWriter.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
 func (w *compressWriter) open(contentLength int64) {
+	w.opened = true
 	header := w.Header()
 
+	// 已升级的连接
+	if w.hijacked {
+		w.ctx.Set(CONTEXT, "identity")
+		return
+	}
+
+	// 已编码的内容 如预压缩文件
+	if val := header.Get("Content-Encoding"); val != "" && val != "identity" {
+		w.ctx.Set(CONTEXT, val)
+		return
+	}
+
+	// 路由覆盖
+	var force bool
+	if val, ok := w.ctx.Get(CONTEXT_OVERRIDE); ok {
+		if force, _ = val.(bool); !force {
+			w.ctx.Set(CONTEXT, "identity")
+			return
+		}
+	}
+
 	// 长度过滤
 	if contentLength == -1 {
 		if val, ok := header["Content-Length"]; ok && len(val) != 0 {
-			if val, err := strconv.ParseInt(val[0], 10, 64); err != nil {
+			if val, err := strconv.ParseInt(val[0], 10, 64); err == nil {
 				contentLength = val
 			}
 		}
 	}
 
-	if w.config.MinLength >= contentLength {
+	// -1 为流式响应 不过滤长度
+	if !force && contentLength != -1 && w.config.MinLength >= contentLength {
+		w.ctx.Set(CONTEXT, "identity")
 		return
 	}
 
 	// 内容类型过滤
-	var contentType []string
-	var ok bool
-	if contentType, ok = header["Content-Type"]; !ok || len(contentType) == 0 {
-		return
+	var mediatype string
+	if contentType, ok := header["Content-Type"]; ok && len(contentType) != 0 {
+		mediatype, _, _ = mime.ParseMediaType(contentType[0])
 	}
-	mediatype, _, _ := mime.ParseMediaType(contentType[0])
-	var typeMatch bool
-	for _, typ := range w.config.Types {
-		if mediatype == typ {
-			typeMatch = true
-			break
-		}
+
+	// SSE 需要逐条推送 不压缩
+	if mediatype == "text/event-stream" {
+		w.ctx.Set(CONTEXT, "identity")
+		return
 	}
-	if !typeMatch {
+
+	if !force && (mediatype == "" || !MatchType(w.config.Types, mediatype) || MatchType(w.config.Excludes, mediatype)) {
+		w.ctx.Set(CONTEXT, "identity")
 		return
 	}
 
@@ -155,26 +351,41 @@ func (w *compressWriter) open(contentLength int64) {
 
 	switch w.encoding {
 	case "br":
-		writer := cbrotli.NewWriter(w.ResponseWriter, cbrotli.WriterOptions{
-			Quality: w.config.BrQuality,
-			LGWin:   w.config.BrLGWin,
-		})
+		writer := w.brPool.Get().(*brotliWriter)
+		writer.Reset(encoderOutput{w})
 		w.writer = writer
 	case "gzip":
 		writer := w.gzipPool.Get().(*gzip.Writer)
-		writer.Reset(w.ResponseWriter)
+		writer.Reset(encoderOutput{w})
 		w.writer = writer
 	}
 }
 
-func (w *compressWriter) close() {
-	switch w.writer.(type) {
+// 结束编码 放回池  可重复调用
+func (w *compressWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	switch writer := w.writer.(type) {
 	case *gzip.Writer:
-		writer := w.writer.(*gzip.Writer)
 		writer.Close()
+		writer.Reset(ioutil.Discard)
 		w.gzipPool.Put(writer)
-	case *cbrotli.Writer:
-		writer := w.writer.(*cbrotli.Writer)
+		w.writer = ioutil.Discard
+	case *brotliWriter:
 		writer.Close()
+		if brotliReusable {
+			w.brPool.Put(writer)
+		}
+		w.writer = ioutil.Discard
+	}
+	return nil
+}
+
+func (o encoderOutput) Write(data []byte) (int, error) {
+	if o.hijacked {
+		return len(data), nil
 	}
+	return o.ResponseWriter.Write(data)
 }
@@ -0,0 +1,150 @@
+package compress
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+func testServer(handler gin.HandlerFunc) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(Middleware(Config{
+		Types:     []string{"text/*"},
+		GzipLevel: gzip.DefaultCompression,
+		BrQuality: 4,
+		BrLGWin:   19,
+	}))
+	engine.GET("/", handler)
+	return httptest.NewServer(engine)
+}
+
+// 每个事件 flush 后客户端立即可以解码  下一个事件等客户端读到后再写
+func streamHandler(next chan struct{}) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Header("Content-Type", ctx.Query("type"))
+		for i := 0; i < 3; i++ {
+			io.WriteString(ctx.Writer, "data: "+strconv.Itoa(i)+"\n\n")
+			ctx.Writer.Flush()
+			select {
+			case <-next:
+			case <-time.After(time.Second * 2):
+				return
+			}
+		}
+	}
+}
+
+func readEvents(t *testing.T, reader io.Reader, next chan struct{}) {
+	buf := bufio.NewReader(reader)
+	for i := 0; i < 3; i++ {
+		line, err := buf.ReadString('\n')
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if line != "data: "+strconv.Itoa(i)+"\n" {
+			t.Fatalf("event %d: %q", i, line)
+		}
+		if _, err = buf.ReadString('\n'); err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		next <- struct{}{}
+	}
+}
+
+func TestFlushStreaming(t *testing.T) {
+	for _, encoding := range []string{"gzip", "br"} {
+		t.Run(encoding, func(t *testing.T) {
+			next := make(chan struct{}, 1)
+			server := testServer(streamHandler(next))
+			defer server.Close()
+
+			req, _ := http.NewRequest(http.MethodGet, server.URL+"/?type=text/plain", nil)
+			req.Header.Set("Accept-Encoding", encoding)
+			res, err := http.DefaultTransport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if val := res.Header.Get("Content-Encoding"); val != encoding {
+				t.Fatalf("Content-Encoding %q", val)
+			}
+
+			var reader io.Reader
+			if encoding == "gzip" {
+				if reader, err = gzip.NewReader(res.Body); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				reader = brotli.NewReader(res.Body)
+			}
+			readEvents(t, reader, next)
+		})
+	}
+}
+
+func TestEventStreamBypass(t *testing.T) {
+	next := make(chan struct{}, 1)
+	server := testServer(streamHandler(next))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/?type=text/event-stream", nil)
+	req.Header.Set("Accept-Encoding", "br, gzip")
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if val := res.Header.Get("Content-Encoding"); val != "" {
+		t.Fatalf("Content-Encoding %q", val)
+	}
+	readEvents(t, res.Body, next)
+}
+
+func TestHijackNoTrailer(t *testing.T) {
+	const raw = "HTTP/1.1 200 OK\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhello"
+	server := testServer(func(ctx *gin.Context) {
+		ctx.Header("Content-Type", "text/plain")
+		conn, rw, err := ctx.Writer.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// 中间件结束后再写  期间不能有编码器的输出
+		go func() {
+			time.Sleep(time.Millisecond * 50)
+			rw.WriteString(raw)
+			rw.Flush()
+			conn.Close()
+		}()
+	})
+	defer server.Close()
+
+	for _, encoding := range []string{"gzip", "br"} {
+		conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\nAccept-Encoding: "+encoding+"\r\n\r\n")
+		conn.SetReadDeadline(time.Now().Add(time.Second * 2))
+		data, err := ioutil.ReadAll(conn)
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != raw {
+			t.Fatalf("%s: %q", encoding, data)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package decompress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/otamoe/gin-server/errs"
+)
+
+type (
+	readCloser struct {
+		io.Reader
+		closers []io.Closer
+	}
+
+	zstdReader struct {
+		io.ReadCloser
+	}
+)
+
+// zstd 最大窗口  帧头声明的窗口超过时 413  解码器在读取数据前按窗口分配内存
+var ZstdMaxWindow uint64 = 8 << 20
+
+var ErrUnsupported = &errs.Error{
+	Message:    http.StatusText(http.StatusUnsupportedMediaType),
+	Type:       "content_encoding",
+	StatusCode: http.StatusUnsupportedMediaType,
+}
+
+var ErrTooLarge = &errs.Error{
+	Message:    http.StatusText(http.StatusRequestEntityTooLarge),
+	Type:       "content_encoding",
+	StatusCode: http.StatusRequestEntityTooLarge,
+}
+
+// 请求体解压  需要在 size.Middleware 之前  限制解压后的长度
+func Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		req := ctx.Request
+		value := req.Header.Get("Content-Encoding")
+		if value == "" || req.Body == nil || req.Body == http.NoBody {
+			ctx.Next()
+			return
+		}
+
+		body := &readCloser{
+			Reader:  req.Body,
+			closers: []io.Closer{req.Body},
+		}
+
+		// 多个编码 按相反顺序解码
+		encodings := strings.Split(value, ",")
+		for i := len(encodings) - 1; i >= 0; i-- {
+			encoding := strings.ToLower(strings.TrimSpace(encodings[i]))
+			if err := body.decode(encoding); err != nil {
+				body.Close()
+				ctx.Error(err)
+				ctx.Abort()
+				return
+			}
+		}
+
+		req.Header.Del("Content-Encoding")
+		req.Header.Del("Content-Length")
+		req.ContentLength = -1
+		req.Body = body
+		ctx.Next()
+	}
+}
+
+func (body *readCloser) decode(encoding string) (err error) {
+	switch encoding {
+	case "", "identity":
+	case "gzip", "x-gzip":
+		var reader *gzip.Reader
+		if reader, err = gzip.NewReader(body.Reader); err != nil {
+			return invalid(encoding, err)
+		}
+		body.Reader = reader
+		body.closers = append(body.closers, reader)
+	case "deflate":
+		var reader io.ReadCloser
+		if reader, err = zlib.NewReader(body.Reader); err != nil {
+			return invalid(encoding, err)
+		}
+		body.Reader = reader
+		body.closers = append(body.closers, reader)
+	case "br":
+		body.Reader = brotli.NewReader(body.Reader)
+	case "zstd":
+		var decoder *zstd.Decoder
+		if decoder, err = zstd.NewReader(body.Reader,
+			zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(ZstdMaxWindow),
+			zstd.WithDecoderMaxMemory(ZstdMaxWindow),
+		); err != nil {
+			return invalid(encoding, err)
+		}
+		reader := &zstdReader{decoder.IOReadCloser()}
+		body.Reader = reader
+		body.closers = append(body.closers, reader)
+	default:
+		e := ErrUnsupported.Clone()
+		e.Value = encoding
+		err = e
+	}
+	return
+}
+
+func invalid(encoding string, err error) error {
+	return &errs.Error{
+		Err:        err,
+		Type:       "content_encoding",
+		Value:      encoding,
+		StatusCode: http.StatusBadRequest,
+	}
+}
+
+func (reader *zstdReader) Read(p []byte) (n int, err error) {
+	n, err = reader.ReadCloser.Read(p)
+	if errors.Is(err, zstd.ErrWindowSizeExceeded) || errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		e := ErrTooLarge.Clone()
+		e.Value = "zstd"
+		err = e
+	}
+	return
+}
+
+func (body *readCloser) Close() (err error) {
+	for i := len(body.closers) - 1; i >= 0; i-- {
+		if e := body.closers[i].Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
@@ -0,0 +1,67 @@
+package decompress
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/otamoe/gin-server/bind"
+	"github.com/otamoe/gin-server/errs"
+)
+
+func testEngine() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(errs.Middleware())
+	engine.Use(Middleware())
+	engine.POST("/", bind.MiddlewareJSON(func(ctx *gin.Context) interface{} {
+		return &map[string]interface{}{}
+	}), func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, ctx.MustGet(bind.CONTEXT))
+	})
+	return engine
+}
+
+func post(engine *gin.Engine, encoding string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", encoding)
+	res := httptest.NewRecorder()
+	engine.ServeHTTP(res, req)
+	return res
+}
+
+func TestZstd(t *testing.T) {
+	encoder, _ := zstd.NewWriter(nil)
+	body := encoder.EncodeAll([]byte(`{"a":1}`), nil)
+	res := post(testEngine(), "zstd", body)
+	if res.Code != http.StatusOK || res.Body.String() != `{"a":1}` {
+		t.Fatalf("%d %s", res.Code, res.Body.String())
+	}
+}
+
+// 帧头声明 256 MiB 窗口  在默认限制内  需要在分配前拒绝
+func TestZstdWindow(t *testing.T) {
+	for _, descriptor := range []byte{0x90, 0xf8} {
+		body := []byte{
+			0x28, 0xb5, 0x2f, 0xfd, // magic
+			0x00,                  // 没有 content size  不是 single segment
+			descriptor,            // window log 10 + exponent
+			0x09, 0x00, 0x00, '{', // 最后一个 raw block  1 字节
+		}
+		res := post(testEngine(), "zstd", body)
+		if res.Code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("%#x: %d %s", descriptor, res.Code, res.Body.String())
+		}
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	res := post(testEngine(), "compress", []byte("{}"))
+	if res.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("%d %s", res.Code, res.Body.String())
+	}
+}
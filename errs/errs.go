@@ -14,6 +14,7 @@ import (
 	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/requestid"
 	validator9 "gopkg.in/go-playground/validator.v9"
 )
 
@@ -21,6 +22,7 @@ type (
 	Errors struct {
 		Errors     []*Error               `json:"errors"`
 		StatusCode int                    `json:"status_code,omitempty"`
+		RequestID  string                 `json:"request_id,omitempty"`
 		Maps       map[string]interface{} `json:"-"`
 	}
 
@@ -45,6 +47,9 @@ func (b *Errors) JSON() map[string]interface{} {
 		"status_code": b.StatusCode,
 		"errors":      b.Errors,
 	}
+	if b.RequestID != "" {
+		json["request_id"] = b.RequestID
+	}
 	for _, e := range b.Errors {
 		for k, v := range e.Maps {
 			json[k] = v
@@ -258,6 +263,7 @@ func Middleware() gin.HandlerFunc {
 
 			errs := &Errors{}
 			errs.StatusCode = ctx.Writer.Status()
+			errs.RequestID = requestid.Get(ctx)
 
 			for _, val := range ctx.Errors {
 				switch val.Err.(type) {
@@ -1,13 +1,20 @@
 package file
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/fs"
+	"mime"
 	"net/http"
 	"os"
 	"path"
 	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/compress"
 	"github.com/otamoe/gin-server/errs"
 	"github.com/otamoe/gin-server/logger"
 )
@@ -17,9 +24,41 @@ type (
 		Root    string
 		Control []string
 		Logger  bool
+
+		// 文件系统 优先于 Root  如 embed.FS  zip.Reader
+		FileSystem http.FileSystem
+		FS         fs.FS
+
+		// 预压缩文件的编码 按优先级排序  nil 为默认  空为禁用
+		Encodings []string
+
+		// 目录索引文件  nil 为 index.html
+		Indexes []string
+
+		// 单页应用 未匹配且无扩展名的路径返回该文件  如 /index.html
+		SPA string
+
+		// 404 页面
+		NotFound string
+
+		// 不处理的路径前缀  如 /api/
+		Excludes []string
+
+		// 资源清单  带哈希的 url 长期缓存
+		Manifest *Manifest
+
+		// 没有修改时间的文件 如 embed.FS  内容哈希
+		hashes *sync.Map
 	}
 )
 
+// 编码 -> 预压缩文件后缀
+var Extensions = map[string]string{
+	"br":   ".br",
+	"zstd": ".zst",
+	"gzip": ".gz",
+}
+
 func filtered(val string) bool {
 	for _, name := range strings.FieldsFunc(val, isSlashRune) {
 		name = strings.TrimSpace(name)
@@ -38,7 +77,20 @@ func isSlashRune(r rune) bool {
 }
 
 func Middleware(c Config) gin.HandlerFunc {
-	fileserver := http.FileServer(http.Dir(c.Root))
+	if c.Encodings == nil {
+		c.Encodings = []string{"br", "zstd", "gzip"}
+	}
+	if c.Indexes == nil {
+		c.Indexes = []string{"index.html"}
+	}
+	c.hashes = &sync.Map{}
+	if c.FileSystem == nil {
+		if c.FS != nil {
+			c.FileSystem = http.FS(c.FS)
+		} else {
+			c.FileSystem = http.Dir(c.Root)
+		}
+	}
 	return func(ctx *gin.Context) {
 		urlPath := ctx.Request.URL.Path
 		if !strings.HasPrefix(urlPath, "/") {
@@ -59,21 +111,190 @@ func Middleware(c Config) gin.HandlerFunc {
 			return
 		}
 
-		name := path.Join(c.Root, urlPath)
-		stats, err := os.Stat(name)
-		if err != nil || stats.IsDir() {
+		for _, prefix := range c.Excludes {
+			if strings.HasPrefix(urlPath, prefix) {
+				ctx.Next()
+				return
+			}
+		}
+
+		// 带哈希的文件名
+		var immutable bool
+		if c.Manifest != nil {
+			if val, ok := c.Manifest.Resolve(urlPath); ok {
+				urlPath = val
+				immutable = true
+			}
+		}
+
+		statusCode := http.StatusOK
+		name, file, stats := c.open(urlPath)
+		if file == nil {
+			if c.SPA != "" && path.Ext(urlPath) == "" {
+				name, file, stats = c.open(c.SPA)
+			} else if c.NotFound != "" {
+				name, file, stats = c.open(c.NotFound)
+				statusCode = http.StatusNotFound
+			}
+		}
+		if file == nil {
 			ctx.Next()
 			return
 		}
+		defer file.Close()
 
 		if !c.Logger {
 			ctx.Set(logger.CONTEXT, nil)
 		}
 
-		ctx.Header("cache-control", strings.Join(c.Control, ","))
-		ctx.Header("etag", "\""+fmt.Sprint(stats.ModTime().Unix())+"\"")
+		// 404 页面 不缓存
+		if statusCode != http.StatusOK {
+			ctx.DataFromReader(statusCode, stats.Size(), contentType(name), file, map[string]string{})
+			ctx.Abort()
+			return
+		}
+
+		if immutable {
+			ctx.Header("cache-control", strings.Join(ImmutableControl, ","))
+		} else {
+			ctx.Header("cache-control", strings.Join(c.Control, ","))
+		}
+		ctx.Header("etag", c.etag(name, stats, ""))
 
-		fileserver.ServeHTTP(ctx.Writer, ctx.Request)
+		if !c.precompressed(ctx, name, stats) {
+			http.ServeContent(ctx.Writer, ctx.Request, name, stats.ModTime(), file)
+		}
 		ctx.Abort()
 	}
 }
+
+// 打开文件  目录时查找索引文件
+func (c Config) open(name string) (string, http.File, os.FileInfo) {
+	file, stats := c.openFile(name)
+	if file == nil || !stats.IsDir() {
+		return name, file, stats
+	}
+	file.Close()
+	for _, index := range c.Indexes {
+		indexName := path.Join(name, index)
+		if file, stats = c.openFile(indexName); file == nil {
+			continue
+		}
+		if !stats.IsDir() {
+			return indexName, file, stats
+		}
+		file.Close()
+	}
+	return name, nil, nil
+}
+
+func (c Config) openFile(name string) (http.File, os.FileInfo) {
+	file, err := c.FileSystem.Open(name)
+	if err != nil {
+		return nil, nil
+	}
+	stats, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil
+	}
+	return file, stats
+}
+
+// 输出预压缩文件  .br .zst .gz
+func (c Config) precompressed(ctx *gin.Context, name string, stats os.FileInfo) bool {
+	var available []string
+	for _, encoding := range c.Encodings {
+		ext, ok := Extensions[encoding]
+		if !ok {
+			continue
+		}
+		file, encodedStats := c.openFile(name + ext)
+		if file == nil {
+			continue
+		}
+		file.Close()
+		if encodedStats.IsDir() {
+			continue
+		}
+		// 比原文件旧的忽略
+		if encodedStats.ModTime().Before(stats.ModTime()) {
+			continue
+		}
+		available = append(available, encoding)
+	}
+	if len(available) == 0 {
+		return false
+	}
+
+	header := ctx.Writer.Header()
+	if vary := header.Get("Vary"); vary == "" {
+		header.Set("Vary", "Accept-Encoding")
+	} else if !strings.Contains(strings.ToLower(vary), "accept-encoding") {
+		header.Set("Vary", vary+", Accept-Encoding")
+	}
+
+	encoding, ok := compress.Negotiate(ctx.Request.Header, available)
+	if !ok || encoding == "" {
+		return false
+	}
+
+	file, _ := c.openFile(name + Extensions[encoding])
+	if file == nil {
+		return false
+	}
+	defer file.Close()
+
+	header.Set("Content-Type", contentType(name))
+	header.Set("Content-Encoding", encoding)
+	header.Set("Etag", c.etag(name, stats, encoding))
+
+	// 长度 范围 条件请求 由 ServeContent 处理
+	http.ServeContent(ctx.Writer, ctx.Request, name, stats.ModTime(), file)
+	return true
+}
+
+// 清单中的文件和没有修改时间的文件使用内容哈希 否则使用修改时间
+func (c Config) etag(name string, stats os.FileInfo, encoding string) string {
+	var etag string
+	if c.Manifest != nil {
+		etag = c.Manifest.Hash(name)
+	}
+	if etag == "" && stats.ModTime().IsZero() {
+		etag = c.hash(name, stats)
+	}
+	if etag == "" {
+		etag = fmt.Sprint(stats.ModTime().Unix())
+	}
+	if encoding != "" {
+		etag += "-" + encoding
+	}
+	return "\"" + etag + "\""
+}
+
+// 按 文件名 和 长度 缓存
+func (c Config) hash(name string, stats os.FileInfo) string {
+	key := name + ":" + fmt.Sprint(stats.Size())
+	if val, ok := c.hashes.Load(key); ok {
+		return val.(string)
+	}
+	file, _ := c.openFile(name)
+	if file == nil {
+		return ""
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return ""
+	}
+	val := hex.EncodeToString(hash.Sum(nil))[:16]
+	c.hashes.Store(key, val)
+	return val
+}
+
+func contentType(name string) string {
+	if val := mime.TypeByExtension(path.Ext(name)); val != "" {
+		return val
+	}
+	return "application/octet-stream"
+}
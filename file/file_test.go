@@ -0,0 +1,53 @@
+package file
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/gin-gonic/gin"
+)
+
+func get(fsys fstest.MapFS, header http.Header) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(Middleware(Config{FS: fsys}))
+	req := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	res := httptest.NewRecorder()
+	engine.ServeHTTP(res, req)
+	return res
+}
+
+// fs.FS 的修改时间为 0  ETag 需要跟随内容
+func TestFSEtag(t *testing.T) {
+	v1 := get(fstest.MapFS{"app.js": {Data: []byte("console.log(1)")}}, nil)
+	v1Again := get(fstest.MapFS{"app.js": {Data: []byte("console.log(1)")}}, nil)
+	v2 := get(fstest.MapFS{"app.js": {Data: []byte("console.log(2)")}}, nil)
+
+	etag := v1.Header().Get("Etag")
+	if v1.Code != http.StatusOK || etag == "" {
+		t.Fatalf("%d %q", v1.Code, etag)
+	}
+	if val := v1Again.Header().Get("Etag"); val != etag {
+		t.Fatalf("same content %q != %q", val, etag)
+	}
+	if val := v2.Header().Get("Etag"); val == etag {
+		t.Fatalf("different content same etag %q", val)
+	}
+	if val := v1.Header().Get("Last-Modified"); val != "" {
+		t.Fatalf("Last-Modified %q", val)
+	}
+
+	res := get(fstest.MapFS{"app.js": {Data: []byte("console.log(2)")}}, http.Header{"If-None-Match": {etag}})
+	if res.Code != http.StatusOK {
+		t.Fatalf("stale etag %d", res.Code)
+	}
+	res = get(fstest.MapFS{"app.js": {Data: []byte("console.log(1)")}}, http.Header{"If-None-Match": {etag}})
+	if res.Code != http.StatusNotModified {
+		t.Fatalf("current etag %d", res.Code)
+	}
+}
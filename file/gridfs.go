@@ -0,0 +1,151 @@
+package file
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/cache"
+	"github.com/otamoe/gin-server/errs"
+	ginResource "github.com/otamoe/gin-server/resource"
+	"github.com/otamoe/gin-server/scope"
+	"github.com/otamoe/gin-server/sign"
+	"github.com/otamoe/gin-server/upload"
+)
+
+type (
+	GridFSConfig struct {
+		upload.GridFS
+
+		// 路由参数 值为 id 或文件名  默认 id
+		Param   string
+		Control []string
+
+		// Content-Disposition 为 attachment
+		Attachment bool
+
+		// 通过 scope 验证权限  sign.Middleware 验证过的请求跳过
+		Scope bool
+	}
+)
+
+var ErrNotFound = &errs.Error{
+	Message:    http.StatusText(http.StatusNotFound),
+	Type:       "not_found",
+	StatusCode: http.StatusNotFound,
+}
+
+func GridFS(c GridFSConfig) gin.HandlerFunc {
+	if c.Param == "" {
+		c.Param = "id"
+	}
+	return func(ctx *gin.Context) {
+		var err error
+		defer func() {
+			if err != nil {
+				ctx.Error(err)
+				ctx.Abort()
+			}
+		}()
+
+		value := strings.TrimPrefix(ctx.Param(c.Param), "/")
+		gridfs := c.Get(ctx)
+
+		var file *mgo.GridFile
+		if bson.IsObjectIdHex(value) {
+			file, err = gridfs.OpenId(bson.ObjectIdHex(value))
+		} else if value != "" {
+			file, err = gridfs.Open(value)
+		} else {
+			err = mgo.ErrNotFound
+		}
+		if err != nil && err != mgo.ErrNotFound {
+			return
+		}
+		notFound := err == mgo.ErrNotFound
+		err = nil
+		if !notFound {
+			defer file.Close()
+		}
+
+		id := value
+		if !notFound {
+			switch val := file.Id().(type) {
+			case bson.ObjectId:
+				id = val.Hex()
+			default:
+				id = fmt.Sprint(val)
+			}
+		}
+
+		// 权限  签名的 url 不需要验证
+		// 不存在的文件也验证  未授权时不能通过 404 判断文件是否存在
+		if c.Scope && !ctx.GetBool(sign.CONTEXT) {
+			resource := ctx.MustGet(ginResource.CONTEXT).(*ginResource.Resource)
+			if resource.Type == "" {
+				resource.Type = "file"
+			}
+			if resource.Action == "" {
+				resource.Action = "read"
+			}
+			if resource.Value == "" {
+				resource.Value = id
+			}
+			if resource.Owner == "" && !notFound {
+				var meta struct {
+					Owner bson.ObjectId `bson:"owner,omitempty"`
+				}
+				if file.GetMeta(&meta) == nil {
+					resource.Owner = meta.Owner
+				}
+			}
+			if _, err = scope.Validate(ctx); err != nil {
+				return
+			}
+		}
+		if notFound {
+			err = ErrNotFound
+			return
+		}
+
+		// 缓存
+		var fileCache *cache.Cache
+		if val, ok := ctx.Get(cache.CONTEXT); ok && val != nil {
+			fileCache = val.(*cache.Cache)
+		} else {
+			fileCache = cache.New(ctx, c.Control)
+		}
+		uploadDate := file.UploadDate()
+		fileCache.LastModified = &uploadDate
+		if md5 := file.MD5(); md5 != "" {
+			fileCache.Etag = md5
+		} else {
+			fileCache.Etag = id
+		}
+		if fileCache.Match() {
+			return
+		}
+
+		name := file.Name()
+		mediatype := file.ContentType()
+		if mediatype == "" {
+			mediatype = contentType(name)
+		}
+		disposition := "inline"
+		if c.Attachment {
+			disposition = "attachment"
+		}
+
+		ctx.Header("Content-Type", mediatype)
+		ctx.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{
+			"filename": name,
+		}))
+
+		// 范围请求 If-Range 由 ServeContent 处理
+		http.ServeContent(ctx.Writer, ctx.Request, name, uploadDate, file)
+	}
+}
@@ -0,0 +1,120 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+type (
+	// 资源清单  逻辑文件名 <-> 带内容哈希的文件名
+	Manifest struct {
+		Prefix string
+		names  map[string]string
+		files  map[string]string
+		hashes map[string]string
+	}
+)
+
+var ImmutableControl = []string{"public", "max-age=31536000", "immutable"}
+
+// 遍历 fsys 计算所有文件的内容哈希  prefix 为生成 url 的前缀 如 CDN 地址
+func NewManifest(fsys fs.FS, prefix string) (manifest *Manifest, err error) {
+	manifest = &Manifest{
+		Prefix: strings.TrimSuffix(prefix, "/"),
+		names:  map[string]string{},
+		files:  map[string]string{},
+		hashes: map[string]string{},
+	}
+	err = fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != "." && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		// 预压缩文件 跟随原文件
+		for _, ext := range Extensions {
+			if !strings.HasSuffix(name, ext) {
+				continue
+			}
+			if _, err := fs.Stat(fsys, strings.TrimSuffix(name, ext)); err == nil {
+				return nil
+			}
+		}
+
+		hash, err := hashFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		manifest.Add("/"+name, hash)
+		return nil
+	})
+	return
+}
+
+func hashFile(fsys fs.FS, name string) (string, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err = io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// 添加文件  /js/app.js -> /js/app.3f2a9c1b.js
+func (manifest *Manifest) Add(name string, hash string) {
+	short := hash
+	if len(short) > 8 {
+		short = short[:8]
+	}
+	ext := path.Ext(name)
+	file := strings.TrimSuffix(name, ext) + "." + short + ext
+	manifest.names[name] = file
+	manifest.files[file] = name
+	manifest.hashes[name] = hash
+}
+
+// 带哈希的文件名 -> 逻辑文件名
+func (manifest *Manifest) Resolve(file string) (name string, ok bool) {
+	name, ok = manifest.files[file]
+	return
+}
+
+// 文件内容哈希
+func (manifest *Manifest) Hash(name string) string {
+	return manifest.hashes[name]
+}
+
+// 资源 url  不在清单中的原样返回
+func (manifest *Manifest) URL(name string) string {
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	if file, ok := manifest.names[name]; ok {
+		name = file
+	}
+	return manifest.Prefix + name
+}
+
+// 模板函数  {{ asset "/js/app.js" }}
+func (manifest *Manifest) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"asset": manifest.URL,
+	}
+}
@@ -1,16 +1,19 @@
 module github.com/otamoe/gin-server
 
 require (
+	github.com/andybalholm/brotli v1.0.4
 	github.com/gin-gonic/gin v1.4.0
 	github.com/globalsign/mgo v0.0.0-20181015135952-eeefdecb41b8
 	github.com/go-playground/locales v0.12.1 // indirect
 	github.com/go-playground/universal-translator v0.16.0 // indirect
 	github.com/go-redis/redis v6.15.2+incompatible
 	github.com/google/brotli v1.0.7
+	github.com/klauspost/compress v1.15.15
 	github.com/leodido/go-urn v1.1.0 // indirect
 	github.com/onsi/ginkgo v1.8.0 // indirect
 	github.com/onsi/gomega v1.5.0 // indirect
 	github.com/otamoe/mgo-model v0.1.1
 	github.com/sirupsen/logrus v1.4.1
+	golang.org/x/image v0.0.0-20190802002840-cff245a6509b
 	gopkg.in/go-playground/validator.v9 v9.28.0
 )
@@ -8,6 +8,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/otamoe/gin-server/compress"
+	"github.com/otamoe/gin-server/decompress"
 	"github.com/otamoe/gin-server/errs"
 	"github.com/otamoe/gin-server/logger"
 	"github.com/otamoe/gin-server/mongo"
@@ -15,6 +16,7 @@ import (
 	ginRedis "github.com/otamoe/gin-server/redis"
 	"github.com/otamoe/gin-server/resource"
 	"github.com/otamoe/gin-server/size"
+	"github.com/otamoe/gin-server/trace"
 )
 
 type (
@@ -25,6 +27,7 @@ type (
 		Logger   *Logger   `json:"logger,omitempty"`
 		Redis    *Redis    `json:"redis,omitempty"`
 		Mongo    *Mongo    `json:"mongo,omitempty"`
+		Trace    *Trace    `json:"trace,omitempty"`
 		gin      *gin.Engine
 	}
 
@@ -56,6 +59,11 @@ func (handler *Handler) Init(server *Server) {
 	} else {
 		handler.Mongo.init(server, handler)
 	}
+	if handler.Trace == nil {
+		handler.Trace = server.Trace
+	} else {
+		handler.Trace.init(server, handler)
+	}
 
 	handler.gin = gin.New()
 
@@ -69,12 +77,27 @@ func (handler *Handler) Init(server *Server) {
 		BrLGWin:   19,
 		BrQuality: 6,
 		Types:     handler.Compress.Types,
+		Excludes:  handler.Compress.Excludes,
 	}))
 
+	var tracer *trace.Tracer
+	if handler.Trace != nil {
+		tracer = handler.Trace.Get()
+	}
+
 	// logger
 	handler.gin.Use(logger.Middleware(logger.Config{
-		Prefix: "[HTTP] ",
-		Logger: handler.Logger.Get(),
+		Prefix:       "[HTTP] ",
+		Handler:      handler.Name,
+		Logger:       handler.Logger.Get(),
+		Sink:         handler.Logger.GetSink(handler.Mongo),
+		Redactor:     handler.Logger.GetRedactor(),
+		Tracer:       tracer,
+		Sampling:     handler.Logger.GetSampling(),
+		Slow:         handler.Logger.Slow,
+		Broadcaster:  handler.Logger.GetBroadcaster(handler.Redis),
+		SlowQuery:    handler.Logger.SlowQuery,
+		ServerTiming: server.ENV == "development",
 	}))
 
 	// errs
@@ -90,6 +113,9 @@ func (handler *Handler) Init(server *Server) {
 		handler.gin.Use(mongo.Middleware(handler.Mongo.Get))
 	}
 
+	// 请求体解压
+	handler.gin.Use(decompress.Middleware())
+
 	// body size
 	handler.gin.Use(size.Middleware(1024 * 512))
 
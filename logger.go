@@ -1,17 +1,91 @@
 package server
 
 import (
+	"context"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
+	"github.com/otamoe/gin-server/logger"
+	mgoModel "github.com/otamoe/mgo-model"
 	"github.com/sirupsen/logrus"
 )
 
 type (
 	Logger struct {
-		File   string `json:"file,omitempty"`
-		logger *logrus.Logger
+		File string      `json:"file,omitempty"`
+		Sink *LoggerSink `json:"sink,omitempty"`
+
+		// 文件切割  SIGHUP 时重新打开
+		MaxSize        int64         `json:"max_size,omitempty"`
+		RotateInterval time.Duration `json:"rotate_interval,omitempty"`
+		MaxBackups     int           `json:"max_backups,omitempty"`
+		Gzip           bool          `json:"gzip,omitempty"`
+
+		// 访问日志格式 common combined logfmt ecs template
+		Format   string `json:"format,omitempty"`
+		Template string `json:"template,omitempty"`
+
+		// 设置后不再输出到 stdout
+		Syslog   *LoggerSyslog `json:"syslog,omitempty"`
+		Journald bool          `json:"journald,omitempty"`
+
+		// 成功请求采样率  慢请求阈值
+		Sampling *LoggerSampling `json:"sampling,omitempty"`
+		Slow     time.Duration   `json:"slow,omitempty"`
+
+		// mongo redis 慢操作阈值
+		SlowQuery time.Duration `json:"slow_query,omitempty"`
+
+		// 实时日志  有 redis 时多实例共享
+		Tail *LoggerTail `json:"tail,omitempty"`
+
+		// 追加到默认脱敏规则
+		RedactKeys    []string `json:"redact_keys,omitempty"`
+		RedactHeaders []string `json:"redact_headers,omitempty"`
+
+		logger      *logrus.Logger
+		rotate      *logger.Rotate
+		sink        *logger.Sink
+		syslog      *logger.Syslog
+		journald    *logger.Journald
+		broadcaster *logger.Broadcaster
+		redactor    *logger.Redactor
+		once        sync.Once
+		tailOnce    sync.Once
+	}
+
+	// network: udp tcp unix unixgram
+	LoggerSyslog struct {
+		Network  string `json:"network,omitempty"`
+		Address  string `json:"address,omitempty"`
+		Tag      string `json:"tag,omitempty"`
+		Facility string `json:"facility,omitempty"`
+	}
+
+	LoggerSampling struct {
+		Rate   float64            `json:"rate"`
+		Routes map[string]float64 `json:"routes,omitempty"`
+	}
+
+	LoggerTail struct {
+		Channel string `json:"channel,omitempty"`
+		Buffer  int    `json:"buffer,omitempty"`
+	}
+
+	// 访问日志写入 mongo
+	LoggerSink struct {
+		QueueSize     int           `json:"queue_size,omitempty"`
+		BatchSize     int           `json:"batch_size,omitempty"`
+		FlushInterval time.Duration `json:"flush_interval,omitempty"`
+
+		// created_at TTL 索引  0 不过期
+		Retention time.Duration `json:"retention,omitempty"`
 	}
 )
 
@@ -41,15 +115,90 @@ func (config *Logger) init(server *Server, handler *Handler) {
 	})
 
 	config.logger.SetOutput(os.Stdout)
+	if config.Syslog != nil || config.Journald {
+		config.logger.SetOutput(ioutil.Discard)
+	}
+	if config.Syslog != nil {
+		config.syslog = &logger.Syslog{
+			Network: config.Syslog.Network,
+			Address: config.Syslog.Address,
+			Tag:     config.Syslog.Tag,
+		}
+		if config.Syslog.Facility != "" {
+			facility, ok := logger.SyslogFacilities[config.Syslog.Facility]
+			if !ok {
+				panic("Logger: unknown syslog facility " + config.Syslog.Facility)
+			}
+			config.syslog.Facility = &facility
+		}
+		if config.syslog.Tag == "" && server != nil {
+			config.syslog.Tag = server.Name
+		}
+		config.logger.AddHook(config.syslog.Start())
+	}
+	if config.Journald {
+		config.journald = &logger.Journald{}
+		if server != nil {
+			config.journald.Identifier = server.Name
+		}
+		// 标准输出已关闭  socket 不可用时不能丢弃日志
+		if err := config.journald.Dial(); err != nil {
+			panic("Logger: journald " + err.Error())
+		}
+		config.logger.AddHook(config.journald)
+	}
 	if config.File != "" {
-		writer, err := os.OpenFile(config.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-		if err != nil {
+		config.rotate = &logger.Rotate{
+			Filename:   config.File,
+			MaxSize:    config.MaxSize,
+			Interval:   config.RotateInterval,
+			MaxBackups: config.MaxBackups,
+			Gzip:       config.Gzip,
+		}
+		if err := config.rotate.Reopen(); err != nil {
 			panic(err)
 		}
 		config.logger.SetFormatter(&logrus.JSONFormatter{
 			TimestampFormat: time.RFC3339,
 		})
-		config.logger.SetOutput(writer)
+		config.logger.SetOutput(config.rotate)
+
+		// 外部 logrotate
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := config.rotate.Reopen(); err != nil {
+					logrus.Error("Logger Reopen:", err)
+				}
+			}
+		}()
+	}
+
+	if config.Format != "" {
+		formatter := &logger.AccessFormatter{
+			Layout:   config.Format,
+			Fallback: config.logger.Formatter,
+		}
+		if config.Template != "" {
+			formatter.Layout = logger.LayoutTemplate
+			formatter.Template = template.Must(template.New("logger").Parse(config.Template))
+		}
+		valid := false
+		for _, layout := range logger.Layouts {
+			valid = valid || layout == formatter.Layout
+		}
+		if !valid {
+			panic("Logger: unknown format " + formatter.Layout)
+		}
+		config.logger.SetFormatter(formatter)
+	}
+
+	if len(config.RedactKeys) != 0 || len(config.RedactHeaders) != 0 {
+		config.redactor = &logger.Redactor{
+			Keys:    append(append([]string{}, logger.DefaultRedactor.Keys...), config.RedactKeys...),
+			Headers: append(append([]string{}, logger.DefaultRedactor.Headers...), config.RedactHeaders...),
+		}
 	}
 
 	if handler == nil {
@@ -60,3 +209,88 @@ func (config *Logger) init(server *Server, handler *Handler) {
 func (config *Logger) Get() *logrus.Logger {
 	return config.logger
 }
+
+func (config *Logger) GetSampling() *logger.Sampling {
+	if config.Sampling == nil {
+		return nil
+	}
+	return &logger.Sampling{
+		Rate:   config.Sampling.Rate,
+		Routes: config.Sampling.Routes,
+	}
+}
+
+func (config *Logger) GetRedactor() *logger.Redactor {
+	return config.redactor
+}
+
+func (config *Logger) GetSink(mongo *Mongo) *logger.Sink {
+	if config.Sink == nil || mongo == nil {
+		return nil
+	}
+	config.once.Do(func() {
+		config.sink = (&logger.Sink{
+			GetSession:    mongo.Get,
+			QueueSize:     config.Sink.QueueSize,
+			BatchSize:     config.Sink.BatchSize,
+			FlushInterval: config.Sink.FlushInterval,
+			Logrus:        config.logger,
+		}).Start()
+
+		// 同步索引
+		logger.SetRetention(config.Sink.Retention)
+		go func() {
+			session := mongo.Get()
+			defer session.Close()
+			if _, err := logger.Model.Update(context.WithValue(context.Background(), mgoModel.CONTEXT, session)); err != nil {
+				config.logger.WithError(err).Error("[LOGGER] update indexs")
+			}
+		}()
+	})
+	return config.sink
+}
+
+func (config *Logger) GetBroadcaster(redis *Redis) *logger.Broadcaster {
+	if config.Tail == nil {
+		return nil
+	}
+	config.tailOnce.Do(func() {
+		config.broadcaster = &logger.Broadcaster{
+			Channel: config.Tail.Channel,
+			Buffer:  config.Tail.Buffer,
+			Logrus:  config.logger,
+		}
+		if redis != nil {
+			config.broadcaster.Redis = redis.Get()
+		}
+		config.broadcaster.Start()
+	})
+	return config.broadcaster
+}
+
+func (config *Logger) Close(ctx context.Context) (err error) {
+	if config.sink != nil {
+		err = config.sink.Close(ctx)
+	}
+	if config.broadcaster != nil {
+		if e := config.broadcaster.Close(ctx); err == nil {
+			err = e
+		}
+	}
+	if config.syslog != nil {
+		if e := config.syslog.Close(ctx); err == nil {
+			err = e
+		}
+	}
+	if config.journald != nil {
+		if e := config.journald.Close(ctx); err == nil {
+			err = e
+		}
+	}
+	if config.rotate != nil {
+		if e := config.rotate.Close(); err == nil {
+			err = e
+		}
+	}
+	return
+}
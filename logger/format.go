@@ -0,0 +1,273 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// 访问日志格式  其他日志使用 Fallback
+	// Layout: common combined logfmt ecs template
+	AccessFormatter struct {
+		Layout   string
+		Template *template.Template
+		Fallback logrus.Formatter
+	}
+
+	// 模板数据
+	Access struct {
+		*Logger
+		Time    time.Time
+		Level   string
+		Message string
+		Referer string
+		Proto   string
+		Bytes   int
+		Data    logrus.Fields
+	}
+)
+
+const (
+	LayoutCommon   = "common"
+	LayoutCombined = "combined"
+	LayoutLogfmt   = "logfmt"
+	LayoutECS      = "ecs"
+	LayoutTemplate = "template"
+)
+
+var Layouts = []string{LayoutCommon, LayoutCombined, LayoutLogfmt, LayoutECS, LayoutTemplate}
+
+func (logger *Logger) RawPath() string {
+	rawPath := logger.Path
+	if val := logger.Query.Encode(); val != "" {
+		rawPath += "?" + val
+	}
+	return rawPath
+}
+
+func (f *AccessFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	access := NewAccess(entry)
+	if access == nil {
+		fallback := f.Fallback
+		if fallback == nil {
+			fallback = &logrus.TextFormatter{FullTimestamp: true, TimestampFormat: time.RFC3339}
+		}
+		return fallback.Format(entry)
+	}
+
+	buf := entry.Buffer
+	if buf == nil {
+		buf = &bytes.Buffer{}
+	}
+	switch f.Layout {
+	case LayoutCommon:
+		formatCommon(buf, access)
+	case LayoutCombined:
+		formatCommon(buf, access)
+		fmt.Fprintf(buf, " %s %s", quote(access.Referer), quote(access.UserAgent))
+	case LayoutLogfmt:
+		formatLogfmt(buf, access)
+	case LayoutECS:
+		if err := formatECS(buf, access); err != nil {
+			return nil, err
+		}
+	case LayoutTemplate:
+		if f.Template == nil {
+			return nil, fmt.Errorf("logger: template layout without template")
+		}
+		if err := f.Template.Execute(buf, access); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("logger: unknown layout %q", f.Layout)
+	}
+	if b := buf.Bytes(); len(b) == 0 || b[len(b)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// entry 不是访问日志时 返回 nil
+func NewAccess(entry *logrus.Entry) *Access {
+	if entry.Context == nil {
+		return nil
+	}
+	logger, ok := entry.Context.Value(CONTEXT).(*Logger)
+	if !ok || logger == nil {
+		return nil
+	}
+	access := &Access{
+		Logger:  logger,
+		Time:    entry.Time,
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+		Bytes:   logger.SentBytes,
+		Data:    entry.Data,
+	}
+	if ctx, ok := entry.Context.(*gin.Context); ok {
+		access.Referer = ctx.Request.Referer()
+		access.Proto = ctx.Request.Proto
+	}
+	if access.Proto == "" {
+		access.Proto = "HTTP/1.1"
+	}
+	return access
+}
+
+// %h %l %u %t "%r" %>s %b
+func formatCommon(buf *bytes.Buffer, access *Access) {
+	user := "-"
+	if access.UserID != "" {
+		user = access.UserID.Hex()
+	}
+	size := "-"
+	if access.Bytes > 0 {
+		size = strconv.Itoa(access.Bytes)
+	}
+	fmt.Fprintf(buf, "%s - %s [%s] %s %d %s",
+		dash(access.IP),
+		user,
+		access.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		quote(access.Method+" "+access.RawPath()+" "+access.Proto),
+		access.StatusCode,
+		size,
+	)
+}
+
+func formatLogfmt(buf *bytes.Buffer, access *Access) {
+	writeLogfmt(buf, "time", access.Time.Format(time.RFC3339))
+	writeLogfmt(buf, "level", access.Level)
+	writeLogfmt(buf, "msg", access.Message)
+	writeLogfmt(buf, "method", access.Method)
+	writeLogfmt(buf, "path", access.RawPath())
+	writeLogfmt(buf, "status", access.StatusCode)
+	writeLogfmt(buf, "bytes", access.Bytes)
+
+	keys := make([]string, 0, len(access.Data))
+	for key := range access.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeLogfmt(buf, key, access.Data[key])
+	}
+}
+
+func writeLogfmt(buf *bytes.Buffer, key string, val interface{}) {
+	if buf.Len() != 0 {
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(key)
+	buf.WriteByte('=')
+
+	var s string
+	switch val := val.(type) {
+	case string:
+		s = val
+	case error:
+		s = val.Error()
+	case time.Duration:
+		s = val.String()
+	case fmt.Stringer:
+		s = val.String()
+	default:
+		s = fmt.Sprint(val)
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\t\r\n") {
+		s = strconv.Quote(s)
+	}
+	buf.WriteString(s)
+}
+
+// Elastic Common Schema
+func formatECS(buf *bytes.Buffer, access *Access) error {
+	labels := map[string]interface{}{}
+	for key, val := range access.Data {
+		switch val := val.(type) {
+		case error:
+			labels[key] = val.Error()
+		case time.Duration:
+			labels[key] = val.String()
+		default:
+			labels[key] = val
+		}
+	}
+
+	doc := map[string]interface{}{
+		"@timestamp": access.Time.Format(time.RFC3339Nano),
+		"message":    access.Message,
+		"ecs":        map[string]interface{}{"version": "1.6.0"},
+		"log":        map[string]interface{}{"level": access.Level},
+		"event": map[string]interface{}{
+			"id":       access.ID.Hex(),
+			"kind":     "event",
+			"category": []string{"web"},
+			"duration": access.Latency.Nanoseconds(),
+			"outcome":  outcome(access.StatusCode),
+		},
+		"http": map[string]interface{}{
+			"version": strings.TrimPrefix(access.Proto, "HTTP/"),
+			"request": map[string]interface{}{
+				"id":       access.RequestID,
+				"method":   access.Method,
+				"referrer": access.Referer,
+				"body":     map[string]interface{}{"bytes": access.RequestBytes},
+			},
+			"response": map[string]interface{}{
+				"status_code": access.StatusCode,
+				"mime_type":   access.ContentType,
+				"body":        map[string]interface{}{"bytes": access.Bytes},
+			},
+		},
+		"url": map[string]interface{}{
+			"scheme":   access.Scheme,
+			"domain":   access.Host,
+			"path":     access.Path,
+			"query":    access.Query.Encode(),
+			"original": access.RawPath(),
+		},
+		"client":     map[string]interface{}{"ip": access.IP},
+		"user_agent": map[string]interface{}{"original": access.UserAgent},
+		"labels":     labels,
+	}
+	if access.UserID != "" {
+		doc["user"] = map[string]interface{}{"id": access.UserID.Hex()}
+	}
+	if access.ErrorsText != "" {
+		doc["error"] = map[string]interface{}{"message": access.ErrorsText}
+	}
+
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(doc)
+}
+
+func outcome(statusCode int) string {
+	if statusCode >= 400 {
+		return "failure"
+	}
+	return "success"
+}
+
+func dash(val string) string {
+	if val == "" {
+		return "-"
+	}
+	return val
+}
+
+func quote(val string) string {
+	if val == "" {
+		return `"-"`
+	}
+	return strconv.Quote(val)
+}
@@ -0,0 +1,173 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// systemd journal 原生协议  字段转为大写的 journal 字段
+	// 异步发送 队列满时丢弃
+	Journald struct {
+		Socket     string
+		Identifier string
+		QueueSize  int
+
+		queue   chan []byte
+		done    chan struct{}
+		dropped uint64
+		once    sync.Once
+		mutex   sync.RWMutex
+		closed  bool
+
+		journald
+	}
+)
+
+var JournaldSocket = "/run/systemd/journal/socket"
+
+func (hook *Journald) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (hook *Journald) Start() *Journald {
+	hook.once.Do(func() {
+		if hook.Socket == "" {
+			hook.Socket = JournaldSocket
+		}
+		if hook.QueueSize == 0 {
+			hook.QueueSize = 4096
+		}
+		hook.queue = make(chan []byte, hook.QueueSize)
+		hook.done = make(chan struct{})
+		go hook.run()
+	})
+	return hook
+}
+
+// 连接 socket  用于启动时检查 journald 是否可用
+func (hook *Journald) Dial() error {
+	hook.Start()
+	return hook.dial(hook.Socket)
+}
+
+// 不阻塞  队列满或已关闭时丢弃
+func (hook *Journald) Fire(entry *logrus.Entry) error {
+	hook.Start()
+	message := hook.format(entry)
+
+	hook.mutex.RLock()
+	defer hook.mutex.RUnlock()
+	if hook.closed {
+		atomic.AddUint64(&hook.dropped, 1)
+		return nil
+	}
+	select {
+	case hook.queue <- message:
+	default:
+		atomic.AddUint64(&hook.dropped, 1)
+	}
+	return nil
+}
+
+func (hook *Journald) Dropped() uint64 {
+	return atomic.LoadUint64(&hook.dropped)
+}
+
+// 停止接收 发送队列中剩余的记录
+func (hook *Journald) Close(ctx context.Context) error {
+	hook.Start()
+	hook.mutex.Lock()
+	if !hook.closed {
+		hook.closed = true
+		close(hook.queue)
+	}
+	hook.mutex.Unlock()
+	select {
+	case <-hook.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (hook *Journald) run() {
+	defer close(hook.done)
+	for message := range hook.queue {
+		if hook.send(hook.Socket, message) != nil {
+			atomic.AddUint64(&hook.dropped, 1)
+		}
+	}
+	hook.close()
+}
+
+func (hook *Journald) format(entry *logrus.Entry) []byte {
+	identifier := hook.Identifier
+	if identifier == "" {
+		identifier = filepath.Base(os.Args[0])
+	}
+
+	buf := &bytes.Buffer{}
+	journalField(buf, "MESSAGE", entry.Message)
+	journalField(buf, "PRIORITY", strconv.Itoa(severity(entry.Level)))
+	journalField(buf, "SYSLOG_IDENTIFIER", identifier)
+	if NewAccess(entry) != nil {
+		journalField(buf, "MESSAGE_ID", "access")
+	}
+
+	keys := make([]string, 0, len(entry.Data))
+	for key := range entry.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if name := journalName(key); name != "" {
+			journalField(buf, name, fieldString(entry.Data[key]))
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// 含换行的值 使用 长度前缀格式
+func journalField(buf *bytes.Buffer, name string, val string) {
+	buf.WriteString(name)
+	if strings.ContainsRune(val, '\n') {
+		buf.WriteByte('\n')
+		binary.Write(buf, binary.LittleEndian, uint64(len(val)))
+	} else {
+		buf.WriteByte('=')
+	}
+	buf.WriteString(val)
+	buf.WriteByte('\n')
+}
+
+// 大写字母 数字 下划线  不能以下划线开头
+func journalName(val string) string {
+	result := make([]byte, 0, len(val))
+	for i := 0; i < len(val) && len(result) < 64; i++ {
+		c := val[i]
+		switch {
+		case c >= 'a' && c <= 'z':
+			result = append(result, c-32)
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+			result = append(result, c)
+		case len(result) != 0:
+			result = append(result, '_')
+		}
+	}
+	if len(result) != 0 && result[0] >= '0' && result[0] <= '9' {
+		result = append([]byte{'F', '_'}, result...)
+	}
+	return string(result)
+}
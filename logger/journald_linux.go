@@ -0,0 +1,78 @@
+//go:build linux
+// +build linux
+
+package logger
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+)
+
+type journald struct {
+	mutex sync.Mutex
+	conn  *net.UnixConn
+}
+
+func (j *journald) dial(socket string) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.connect(socket)
+}
+
+// 需要持有锁
+func (j *journald) connect(socket string) (err error) {
+	if j.conn == nil {
+		j.conn, err = net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	}
+	return
+}
+
+func (j *journald) send(socket string, data []byte) (err error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if err = j.connect(socket); err != nil {
+		return
+	}
+	if _, err = j.conn.Write(data); err == nil {
+		return
+	}
+
+	// 数据报太大  通过临时文件描述符发送
+	if opErr, ok := err.(*net.OpError); ok {
+		if sysErr, ok := opErr.Err.(*os.SyscallError); ok && (sysErr.Err == syscall.EMSGSIZE || sysErr.Err == syscall.ENOBUFS) {
+			return j.sendFile(data)
+		}
+	}
+	j.conn.Close()
+	j.conn = nil
+	return
+}
+
+func (j *journald) sendFile(data []byte) (err error) {
+	file, err := ioutil.TempFile("/dev/shm", "journal.")
+	if err != nil {
+		if file, err = ioutil.TempFile("", "journal."); err != nil {
+			return
+		}
+	}
+	defer file.Close()
+	os.Remove(file.Name())
+	if _, err = file.Write(data); err != nil {
+		return
+	}
+	_, _, err = j.conn.WriteMsgUnix(nil, syscall.UnixRights(int(file.Fd())), nil)
+	return
+}
+
+func (j *journald) close() (err error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.conn != nil {
+		err = j.conn.Close()
+		j.conn = nil
+	}
+	return
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package logger
+
+import (
+	"errors"
+)
+
+type journald struct{}
+
+var errJournald = errors.New("journald is only supported on linux")
+
+func (j *journald) dial(socket string) error {
+	return errJournald
+}
+
+func (j *journald) send(socket string, data []byte) error {
+	return errJournald
+}
+
+func (j *journald) close() error {
+	return nil
+}
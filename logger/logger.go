@@ -1,18 +1,24 @@
 package logger
 
 import (
-	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	"github.com/otamoe/gin-server/bind"
+	"github.com/otamoe/gin-server/compress"
+	"github.com/otamoe/gin-server/requestid"
 	ginResource "github.com/otamoe/gin-server/resource"
+	"github.com/otamoe/gin-server/size"
+	"github.com/otamoe/gin-server/stats"
+	"github.com/otamoe/gin-server/trace"
 	mgoModel "github.com/otamoe/mgo-model"
 	"github.com/sirupsen/logrus"
 )
@@ -21,27 +27,67 @@ type (
 	Config struct {
 		Prefix string
 		Logger *logrus.Logger
+		Sink   *Sink
+
+		// handler 名称
+		Handler string
+
+		// 为空使用 DefaultRedactor
+		Redactor *Redactor
+
+		// 每个请求一个 span
+		Tracer *trace.Tracer
+
+		// 为空不采样
+		Sampling *Sampling
+
+		// 超过时 升级为 warn 并记录耗时明细
+		Slow time.Duration
+
+		// 实时日志
+		Broadcaster *Broadcaster
+
+		// mongo redis 慢操作阈值  0 不记录
+		SlowQuery time.Duration
+
+		// 输出 Server-Timing 响应头  用于开发环境
+		ServerTiming bool
 	}
+	// 请求中 其他 goroutine 只能调用 AddTiming  其他字段由中间件设置
 	Logger struct {
 		mgoModel.DocumentBase `json:"-" bson:"-" binding:"-"`
-		ID                    bson.ObjectId          `json:"_id" bson:"_id"`
-		TokenID               bson.ObjectId          `json:"token_id,omitempty" bson:"token,omitempty"`
-		UserID                bson.ObjectId          `json:"user_id,omitempty" bson:"user,omitempty"`
-		IP                    string                 `json:"ip,omitempty" bson:"ip,omitempty"`
-		Method                string                 `json:"method,omitempty" bson:"method,omitempty"`
-		Scheme                string                 `json:"scheme,omitempty" bson:"scheme,omitempty"`
-		Host                  string                 `json:"host,omitempty" bson:"host,omitempty"`
-		Path                  string                 `json:"path,omitempty" bson:"path,omitempty"`
-		Query                 url.Values             `json:"query,omitempty" bson:"query,omitempty"`
-		Params                map[string]string      `json:"params,omitempty" bson:"params,omitempty"`
-		Resource              ginResource.Resource   `json:"resource,omitempty" bson:"resource,omitempty"`
-		Bind                  map[string]interface{} `json:"bind,omitempty" bson:"bind,omitempty"`
-		Latency               time.Duration          `json:"latency,omitempty" bson:"latency,omitempty"`
-		StatusCode            int                    `json:"status_code,omitempty" bson:"status_code,omitempty"`
-		ErrorsText            string                 `json:"errors_text,omitempty" bson:"errors_text,omitempty"`
-		Fields                map[string]interface{} `json:"fields,omitempty" bson:"fields,omitempty"`
-		CreatedAt             *time.Time             `json:"created_at" bson:"created_at"`
-		Logrus                *logrus.Logger         `json:"-" bson:"-" binding:"-"`
+		ID                    bson.ObjectId            `json:"_id" bson:"_id"`
+		RequestID             string                   `json:"request_id,omitempty" bson:"request_id,omitempty"`
+		TraceID               string                   `json:"trace_id,omitempty" bson:"trace_id,omitempty"`
+		Handler               string                   `json:"handler,omitempty" bson:"handler,omitempty"`
+		TokenID               bson.ObjectId            `json:"token_id,omitempty" bson:"token,omitempty"`
+		UserID                bson.ObjectId            `json:"user_id,omitempty" bson:"user,omitempty"`
+		IP                    string                   `json:"ip,omitempty" bson:"ip,omitempty"`
+		Method                string                   `json:"method,omitempty" bson:"method,omitempty"`
+		Scheme                string                   `json:"scheme,omitempty" bson:"scheme,omitempty"`
+		Host                  string                   `json:"host,omitempty" bson:"host,omitempty"`
+		Path                  string                   `json:"path,omitempty" bson:"path,omitempty"`
+		Query                 url.Values               `json:"query,omitempty" bson:"query,omitempty"`
+		Params                map[string]string        `json:"params,omitempty" bson:"params,omitempty"`
+		Resource              ginResource.Resource     `json:"resource,omitempty" bson:"resource,omitempty"`
+		Bind                  map[string]interface{}   `json:"bind,omitempty" bson:"bind,omitempty"`
+		Latency               time.Duration            `json:"latency,omitempty" bson:"latency,omitempty"`
+		RequestBytes          int64                    `json:"request_bytes,omitempty" bson:"request_bytes,omitempty"`
+		ResponseBytes         int                      `json:"response_bytes,omitempty" bson:"response_bytes,omitempty"`
+		SentBytes             int                      `json:"sent_bytes,omitempty" bson:"sent_bytes,omitempty"`
+		ContentType           string                   `json:"content_type,omitempty" bson:"content_type,omitempty"`
+		Encoding              string                   `json:"encoding,omitempty" bson:"encoding,omitempty"`
+		UserAgent             string                   `json:"user_agent,omitempty" bson:"user_agent,omitempty"`
+		Operations            map[string]stats.Stats   `json:"operations,omitempty" bson:"operations,omitempty"`
+		Slow                  bool                     `json:"slow,omitempty" bson:"slow,omitempty"`
+		Timings               map[string]time.Duration `json:"timings,omitempty" bson:"timings,omitempty"`
+		StatusCode            int                      `json:"status_code,omitempty" bson:"status_code,omitempty"`
+		ErrorsText            string                   `json:"errors_text,omitempty" bson:"errors_text,omitempty"`
+		Fields                map[string]interface{}   `json:"fields,omitempty" bson:"fields,omitempty"`
+		CreatedAt             *time.Time               `json:"created_at" bson:"created_at"`
+		Logrus                *logrus.Logger           `json:"-" bson:"-" binding:"-"`
+		mutex                 sync.Mutex
+		finished              bool
 	}
 	BindInterface interface {
 		BindMarshal() map[string]interface{}
@@ -59,11 +105,30 @@ var (
 				Key:        []string{"created_at"},
 				Background: true,
 			},
+			mgo.Index{
+				Key:        []string{"user", "-_id"},
+				Background: true,
+			},
+			mgo.Index{
+				Key:        []string{"token", "-_id"},
+				Background: true,
+			},
+			mgo.Index{
+				Key:        []string{"resource.type", "resource.action", "-_id"},
+				Background: true,
+			},
+			mgo.Index{
+				Key:        []string{"ip", "-_id"},
+				Background: true,
+			},
 		},
 	}
 )
 
 func Middleware(c Config) gin.HandlerFunc {
+	if c.Redactor == nil {
+		c.Redactor = DefaultRedactor
+	}
 	return func(ctx *gin.Context) {
 		req := ctx.Request
 
@@ -81,19 +146,59 @@ func Middleware(c Config) gin.HandlerFunc {
 
 		logger := &Logger{
 			ID:        bson.NewObjectId(),
+			Handler:   c.Handler,
 			IP:        ctx.ClientIP(),
 			Method:    req.Method,
 			Scheme:    url.Scheme,
 			Host:      host,
 			Path:      url.Path,
 			Query:     url.Query(),
+			UserAgent: req.UserAgent(),
 			Fields:    map[string]interface{}{},
 			CreatedAt: now,
 			Logrus:    c.Logger,
 		}
 
+		// 请求 id  无效时使用 logger id
+		if logger.RequestID = req.Header.Get(requestid.Header); !requestid.Valid(logger.RequestID) {
+			logger.RequestID = logger.ID.Hex()
+		}
+		ctx.Header(requestid.Header, logger.RequestID)
+		ctx.Set(requestid.CONTEXT, logger.RequestID)
+
 		ctx.Set(CONTEXT, logger)
 
+		// trace
+		if c.Tracer != nil {
+			parent, _ := trace.Extract(req.Header)
+			span := c.Tracer.Start(parent, "HTTP "+req.Method, trace.KindServer)
+			logger.TraceID = span.Context.TraceID.String()
+			ctx.Set(trace.CONTEXT, span)
+			defer finishSpan(ctx, span, logger)
+		}
+
+		// mongo redis 中间件 记录操作
+		collection := &stats.Collection{Slow: c.SlowQuery}
+		ctx.Set(stats.CONTEXT, collection)
+
+		// compress 之后  ctx.Writer 为压缩的 writer
+		writer := ctx.Writer
+
+		var timing *timingWriter
+		if c.Slow > 0 || c.ServerTiming {
+			timing = &timingWriter{ResponseWriter: ctx.Writer}
+			if c.ServerTiming {
+				timing.header = func(header http.Header) {
+					value := collection.ServerTiming()
+					if value != "" {
+						value += ", "
+					}
+					header.Add("Server-Timing", value+stats.Metric("app", "", time.Since(*now)))
+				}
+			}
+			ctx.Writer = timing
+		}
+
 		defer func() {
 
 			//  被删除
@@ -114,6 +219,67 @@ func Middleware(c Config) gin.HandlerFunc {
 				logger.Latency = time.Now().Sub(*now)
 			}
 
+			// 字节数
+			if reader, ok := ctx.Request.Body.(*size.Reader); ok {
+				logger.RequestBytes = reader.Count
+			}
+			if closer, ok := writer.(io.Closer); ok {
+				// 结束压缩 才能得到压缩后的字节数
+				closer.Close()
+			}
+			if logger.SentBytes = writer.Size(); logger.SentBytes < 0 {
+				logger.SentBytes = 0
+			}
+			logger.ResponseBytes = logger.SentBytes
+			if val, ok := writer.(interface{ UncompressedSize() int }); ok {
+				logger.ResponseBytes = val.UncompressedSize()
+			}
+			logger.ContentType = writer.Header().Get("Content-Type")
+			logger.Encoding = ctx.GetString(compress.CONTEXT)
+
+			// mongo redis 操作
+			logger.Operations = collection.Stats()
+			for name, val := range logger.Operations {
+				logger.Fields[name+"_ops"] = val.Count
+				logger.Fields[name+"_latency"] = val.Duration
+				if val.Errors != 0 {
+					logger.Fields[name+"_errors"] = val.Errors
+				}
+				if len(val.Slows) != 0 {
+					slows := make([]string, len(val.Slows))
+					for i, slow := range val.Slows {
+						slows[i] = slow.Name + " " + slow.Duration.String()
+					}
+					logger.Fields[name+"_slows"] = slows
+				}
+			}
+
+			logger.finish()
+
+			// 慢请求
+			if c.Slow > 0 && logger.Latency >= c.Slow {
+				logger.Slow = true
+				logger.Fields["slow"] = true
+				if timing != nil && !timing.firstByte.IsZero() {
+					logger.addTiming("first_byte", timing.firstByte.Sub(*now))
+				}
+				for name, val := range logger.Operations {
+					logger.addTiming(name, val.Duration)
+				}
+				for name, val := range logger.Timings {
+					logger.Fields["latency_"+name] = val
+				}
+			}
+
+			// 采样  只影响 logrus 输出  sink 和 实时日志 保留全部
+			keep := true
+			if c.Sampling != nil && logger.StatusCode < http.StatusBadRequest && len(ctx.Errors) == 0 && !logger.Slow {
+				var rate float64
+				if keep, rate = c.Sampling.Sample(Route(ctx)); keep && rate < 1 {
+					logger.Fields["sample_rate"] = rate
+				}
+			}
+
 			resource := ctx.MustGet(ginResource.CONTEXT).(*ginResource.Resource)
 			resource.Pre()
 
@@ -127,31 +293,63 @@ func Middleware(c Config) gin.HandlerFunc {
 			}
 
 			// bind
+			bindVal, _ := ctx.Get(bind.CONTEXT)
 			if logger.Bind == nil {
-				if val, ok := ctx.Get(bind.CONTEXT); ok && val != nil {
+				if val := bindVal; val != nil {
 					if bindInterface, ok := val.(BindInterface); ok {
 						logger.Bind = bindInterface.BindMarshal()
 					} else {
-						if jsonBytes, err := json.Marshal(val); err == nil {
-							logger.Bind = map[string]interface{}{}
-							json.Unmarshal(jsonBytes, &logger.Bind)
-						}
+						logger.Bind = c.Redactor.Value(val)
 					}
 				}
 			}
 
+			// 脱敏
+			logger.Bind = c.Redactor.Map(logger.Bind)
+			logger.Query = c.Redactor.BindValues(logger.Query, bindVal)
+			logger.Params = c.Redactor.Params(logger.Params)
+			logger.Path = c.Redactor.Path(logger.Path, ctx.Params)
+
 			// 错误消息
 			logger.ErrorsText = strings.TrimSpace(ctx.Errors.ByType(gin.ErrorTypeAny).String())
 
 			// 错误信息加上 请求头
 			if logger.StatusCode >= 500 {
-				httprequest, _ := httputil.DumpRequest(ctx.Request, false)
+				dump := *ctx.Request
+				dumpURL := *req.URL
+				dumpURL.RawQuery = logger.Query.Encode()
+				dumpURL.Path = logger.Path
+				dumpURL.RawPath = ""
+				dump.URL = &dumpURL
+				dump.RequestURI = ""
+				dump.Header = c.Redactor.Header(req.Header)
+				httprequest, _ := httputil.DumpRequest(&dump, false)
 				logger.ErrorsText += "\n" + strings.TrimSpace(string(httprequest))
 			}
+			logger.ErrorsText = c.Redactor.Text(logger.ErrorsText)
 
+			logger.Fields["request_id"] = logger.RequestID
+			if logger.TraceID != "" {
+				logger.Fields["trace_id"] = logger.TraceID
+			}
 			logger.Fields["ip"] = logger.IP
 			logger.Fields["latency"] = logger.Latency
 
+			logger.Fields["request_bytes"] = logger.RequestBytes
+			logger.Fields["response_bytes"] = logger.ResponseBytes
+			if logger.SentBytes != logger.ResponseBytes {
+				logger.Fields["sent_bytes"] = logger.SentBytes
+			}
+			if logger.ContentType != "" {
+				logger.Fields["content_type"] = logger.ContentType
+			}
+			if logger.Encoding != "" && logger.Encoding != "identity" {
+				logger.Fields["encoding"] = logger.Encoding
+			}
+			if logger.UserAgent != "" {
+				logger.Fields["user_agent"] = logger.UserAgent
+			}
+
 			if logger.TokenID != "" {
 				logger.Fields["token_id"] = logger.TokenID.Hex()
 			}
@@ -200,12 +398,10 @@ func Middleware(c Config) gin.HandlerFunc {
 				logger.Fields["errors_text"] = logger.ErrorsText
 			}
 
-			rawPath := logger.Path
-			if val := logger.Query.Encode(); val != "" {
-				rawPath += "?" + val
-			}
+			rawPath := logger.RawPath()
 
-			with := logger.Logrus.WithFields(logger.Fields)
+			// AccessFormatter 通过 context 取得 logger
+			with := logger.Logrus.WithFields(logger.Fields).WithContext(ctx)
 
 			// callback
 			if val, ok := ctx.Get(CONTEXT_CALLBACK); ok && val != nil {
@@ -214,14 +410,89 @@ func Middleware(c Config) gin.HandlerFunc {
 				}
 			}
 
-			if logger.StatusCode >= 500 {
+			if !keep {
+			} else if logger.StatusCode >= 500 {
 				with.Errorf("%s%s %s %d %s", c.Prefix, logger.ID.Hex(), logger.Method, logger.StatusCode, rawPath)
-			} else if logger.ErrorsText != "" {
+			} else if logger.ErrorsText != "" || logger.Slow {
 				with.Warnf("%s%s %s %d %s", c.Prefix, logger.ID.Hex(), logger.Method, logger.StatusCode, rawPath)
 			} else {
 				with.Infof("%s%s %s %d %s", c.Prefix, logger.ID.Hex(), logger.Method, logger.StatusCode, rawPath)
 			}
+
+			if c.Sink != nil {
+				c.Sink.Push(logger)
+			}
+			if c.Broadcaster != nil {
+				c.Broadcaster.Publish(logger)
+			}
 		}()
 		ctx.Next()
 	}
 }
+
+func finishSpan(ctx *gin.Context, span *trace.Span, logger *Logger) {
+	req := ctx.Request
+	statusCode := ctx.Writer.Status()
+	route := Route(ctx)
+	span.Name = req.Method + " " + route
+	span.SetAttribute("http.method", req.Method)
+	span.SetAttribute("http.route", route)
+	span.SetAttribute("http.target", logger.RawPath())
+	span.SetAttribute("http.host", logger.Host)
+	span.SetAttribute("http.status_code", statusCode)
+	span.SetAttribute("http.user_agent", logger.UserAgent)
+	span.SetAttribute("net.peer.ip", logger.IP)
+	span.SetAttribute("request_id", logger.RequestID)
+	if logger.UserID != "" {
+		span.SetAttribute("enduser.id", logger.UserID.Hex())
+	}
+	if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+		resource := val.(*ginResource.Resource)
+		resource.Pre()
+		span.SetAttribute("resource.type", resource.Type)
+		span.SetAttribute("resource.action", resource.Action)
+		if resource.Value != "" {
+			span.SetAttribute("resource.value", resource.Value)
+		}
+		if resource.Owner != "" {
+			span.SetAttribute("resource.owner", resource.Owner.Hex())
+		}
+	}
+	if statusCode >= 500 {
+		span.Status = trace.StatusError
+		span.StatusMessage = http.StatusText(statusCode)
+	}
+	span.Finish()
+}
+
+// 路由  参数值替换为 :name
+func Route(ctx *gin.Context) string {
+	path := ctx.Request.URL.Path
+	if len(ctx.Params) == 0 {
+		return path
+	}
+	segments := strings.Split(path, "/")
+	replaced := make([]bool, len(segments))
+	for _, param := range ctx.Params {
+		if param.Value == "" {
+			continue
+		}
+		if strings.HasPrefix(param.Value, "/") {
+			// *name 通配
+			if n := strings.Count(param.Value, "/"); strings.HasSuffix(path, param.Value) && n < len(segments) {
+				segments = append(segments[:len(segments)-n], "*"+param.Key)
+				replaced = replaced[:len(segments)]
+				replaced[len(segments)-1] = true
+			}
+			continue
+		}
+		for i, segment := range segments {
+			if !replaced[i] && segment == param.Value {
+				segments[i] = ":" + param.Key
+				replaced[i] = true
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
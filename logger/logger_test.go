@@ -0,0 +1,54 @@
+package logger
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	ginResource "github.com/otamoe/gin-server/resource"
+	"github.com/sirupsen/logrus"
+)
+
+// ServeContent 的 304 没有 body  Server-Timing 在 WriteHeader 时设置
+func TestServerTimingNotModified(t *testing.T) {
+	logrusLogger := logrus.New()
+	logrusLogger.SetOutput(ioutil.Discard)
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(ginResource.Middleware(ginResource.Config{}))
+	engine.Use(Middleware(Config{Logger: logrusLogger, ServerTiming: true}))
+	engine.GET("/app.js", func(ctx *gin.Context) {
+		ctx.Header("ETag", `"v1"`)
+		http.ServeContent(ctx.Writer, ctx.Request, "app.js", time.Time{}, bytes.NewReader([]byte("console.log(1)")))
+	})
+
+	for _, etag := range []string{"", `"v1"`} {
+		req := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		res := httptest.NewRecorder()
+		engine.ServeHTTP(res, req)
+		if etag != "" && res.Code != http.StatusNotModified {
+			t.Fatalf("%q %d", etag, res.Code)
+		}
+		if val := res.Header().Values("Server-Timing"); len(val) != 1 {
+			t.Fatalf("%q %d %q", etag, res.Code, val)
+		}
+	}
+}
+
+func TestAddTimingFinished(t *testing.T) {
+	logger := &Logger{}
+	logger.AddTiming("a", time.Second)
+	logger.finish()
+	logger.AddTiming("a", time.Second)
+	if logger.Timings["a"] != time.Second {
+		t.Fatal(logger.Timings)
+	}
+}
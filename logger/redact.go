@@ -0,0 +1,448 @@
+package logger
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// 写入日志前 脱敏
+	// 结构体标签 log:"-" 忽略  log:"mask" 替换为 Mask
+	// Keys Headers 为不区分大小写的 path.Match 模式
+	Redactor struct {
+		Keys    []string
+		Headers []string
+		Mask    string
+
+		once    sync.Once
+		keys    []string
+		headers []string
+		text    *regexp.Regexp
+	}
+
+	// 当前路径上的指针 map slice  再次遇到为循环引用
+	redactVisit struct {
+		ptr uintptr
+		typ reflect.Type
+	}
+)
+
+var DefaultRedactor = &Redactor{
+	Keys: []string{
+		"*password*",
+		"*passwd*",
+		"*secret*",
+		"*token*",
+		"*api_key*",
+		"*apikey*",
+		"*access_key*",
+		"*private_key*",
+		"authorization",
+		"cookie",
+		"signature",
+	},
+	Headers: []string{
+		"authorization",
+		"proxy-authorization",
+		"cookie",
+		"set-cookie",
+		"x-api-key",
+		"x-*-token",
+		"x-*-key",
+	},
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// 最大嵌套层数  超过时 和 循环引用 替换为 Mask
+var RedactMaxDepth = 32
+
+func (r *Redactor) init() {
+	r.once.Do(func() {
+		if r.Mask == "" {
+			r.Mask = "***"
+		}
+		for _, val := range r.Keys {
+			r.keys = append(r.keys, strings.ToLower(val))
+		}
+		for _, val := range r.Headers {
+			r.headers = append(r.headers, strings.ToLower(val))
+		}
+
+		// key=value  "key":"value"  key: value
+		var patterns []string
+		for _, val := range r.keys {
+			pattern := regexp.QuoteMeta(val)
+			pattern = strings.Replace(pattern, `\*`, `[\w.-]*`, -1)
+			pattern = strings.Replace(pattern, `\?`, `[\w.-]`, -1)
+			patterns = append(patterns, pattern)
+		}
+		for _, val := range r.headers {
+			pattern := regexp.QuoteMeta(val)
+			pattern = strings.Replace(pattern, `\*`, `[\w-]*`, -1)
+			pattern = strings.Replace(pattern, `\?`, `[\w-]`, -1)
+			patterns = append(patterns, pattern)
+		}
+		if len(patterns) != 0 {
+			r.text = regexp.MustCompile(`(?i)(\b(?:` + strings.Join(patterns, "|") + `)"?\s*[:=]\s*"?)((?:(?:bearer|basic|digest)\s+)?[^"&\s,;]+)`)
+		}
+	})
+}
+
+func match(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Redactor) MatchKey(name string) bool {
+	r.init()
+	return match(r.keys, name)
+}
+
+func (r *Redactor) MatchHeader(name string) bool {
+	r.init()
+	return match(r.headers, name)
+}
+
+// 结构体 或 map 转为 map  按标签 和 Keys 脱敏
+func (r *Redactor) Value(val interface{}) map[string]interface{} {
+	r.init()
+	if val, ok := r.value(reflect.ValueOf(val), map[redactVisit]bool{}, 0).(map[string]interface{}); ok {
+		return val
+	}
+	return nil
+}
+
+func (r *Redactor) Map(val map[string]interface{}) map[string]interface{} {
+	if val == nil {
+		return nil
+	}
+	return r.Value(val)
+}
+
+func (r *Redactor) Values(values url.Values) url.Values {
+	if values == nil {
+		return nil
+	}
+	r.init()
+	result := url.Values{}
+	for name, val := range values {
+		if match(r.keys, name) {
+			result[name] = []string{r.Mask}
+		} else {
+			result[name] = val
+		}
+	}
+	return result
+}
+
+// 结构体中 log:"-" log:"mask" 字段对应的 form json 名  同样处理 query
+func (r *Redactor) BindValues(values url.Values, bind interface{}) url.Values {
+	values = r.Values(values)
+	if values == nil || bind == nil {
+		return values
+	}
+	tags := map[string]string{}
+	tagged(reflect.TypeOf(bind), tags)
+	for name, tag := range tags {
+		if _, ok := values[name]; !ok {
+			continue
+		}
+		if tag == "-" {
+			delete(values, name)
+		} else {
+			values[name] = []string{r.Mask}
+		}
+	}
+	return values
+}
+
+func tagged(t reflect.Type, tags map[string]string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous {
+			tagged(field.Type, tags)
+			continue
+		}
+		tag := field.Tag.Get("log")
+		if tag != "-" && tag != "mask" {
+			continue
+		}
+		for _, key := range []string{"form", "json"} {
+			if name := strings.Split(field.Tag.Get(key), ",")[0]; name != "" && name != "-" {
+				tags[name] = tag
+			}
+		}
+	}
+}
+
+func (r *Redactor) Params(params map[string]string) map[string]string {
+	if params == nil {
+		return nil
+	}
+	r.init()
+	result := map[string]string{}
+	for name, val := range params {
+		if match(r.keys, name) {
+			result[name] = r.Mask
+		} else {
+			result[name] = val
+		}
+	}
+	return result
+}
+
+// 路径中 敏感路由参数的值 替换为 Mask  如 /reset/:token
+func (r *Redactor) Path(urlPath string, params gin.Params) string {
+	r.init()
+	segments := strings.Split(urlPath, "/")
+	replaced := make([]bool, len(segments))
+	for _, param := range params {
+		if param.Value == "" {
+			continue
+		}
+		sensitive := match(r.keys, param.Key)
+		if strings.HasPrefix(param.Value, "/") {
+			// *name 通配
+			if n := strings.Count(param.Value, "/"); sensitive && strings.HasSuffix(urlPath, param.Value) && n < len(segments) {
+				segments = append(segments[:len(segments)-n], r.Mask)
+				replaced = replaced[:len(segments)]
+				replaced[len(segments)-1] = true
+			}
+			continue
+		}
+		for i, segment := range segments {
+			if !replaced[i] && segment == param.Value {
+				if sensitive {
+					segments[i] = r.Mask
+				}
+				replaced[i] = true
+				break
+			}
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func (r *Redactor) Header(header http.Header) http.Header {
+	r.init()
+	result := http.Header{}
+	for name, val := range header {
+		if match(r.headers, name) {
+			result[name] = []string{r.Mask}
+		} else {
+			result[name] = val
+		}
+	}
+	return result
+}
+
+// 文本中的 key=value  "key":"value"  Header: value
+func (r *Redactor) Text(text string) string {
+	r.init()
+	if r.text == nil || text == "" {
+		return text
+	}
+	return r.text.ReplaceAllString(text, "${1}"+r.Mask)
+}
+
+func (r *Redactor) value(v reflect.Value, seen map[redactVisit]bool, depth int) interface{} {
+	if depth > RedactMaxDepth {
+		return r.Mask
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		if v.Kind() == reflect.Ptr {
+			key := redactVisit{v.Pointer(), v.Type()}
+			if seen[key] {
+				return r.Mask
+			}
+			seen[key] = true
+			defer delete(seen, key)
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+
+	t := v.Type()
+	if t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return r.leaf(v)
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		result := map[string]interface{}{}
+		r.fields(v, result, seen, depth)
+		return result
+	case reflect.Map:
+		if v.IsNil() {
+			return nil
+		}
+		key := redactVisit{v.Pointer(), t}
+		if seen[key] {
+			return r.Mask
+		}
+		seen[key] = true
+		defer delete(seen, key)
+		result := map[string]interface{}{}
+		for _, key := range v.MapKeys() {
+			name := fmt.Sprint(key.Interface())
+			if match(r.keys, name) {
+				result[name] = r.Mask
+			} else {
+				result[name] = r.value(v.MapIndex(key), seen, depth+1)
+			}
+		}
+		return result
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return r.leaf(v)
+		}
+		if v.Kind() == reflect.Slice {
+			if v.IsNil() {
+				return nil
+			}
+			if v.Len() != 0 {
+				key := redactVisit{v.Pointer(), t}
+				if seen[key] {
+					return r.Mask
+				}
+				seen[key] = true
+				defer delete(seen, key)
+			}
+		}
+		result := make([]interface{}, v.Len())
+		for i := range result {
+			result[i] = r.value(v.Index(i), seen, depth+1)
+		}
+		return result
+	default:
+		return r.leaf(v)
+	}
+}
+
+func (r *Redactor) fields(v reflect.Value, result map[string]interface{}, seen map[redactVisit]bool, depth int) {
+	if depth > RedactMaxDepth {
+		return
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		logTag := field.Tag.Get("log")
+		if logTag == "-" {
+			continue
+		}
+		name := field.Name
+		omitempty := false
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				name = parts[0]
+			} else if field.Anonymous {
+				name = ""
+			}
+			for _, opt := range parts[1:] {
+				if opt == "omitempty" {
+					omitempty = true
+				}
+			}
+		} else if field.Anonymous {
+			name = ""
+		}
+
+		fv := v.Field(i)
+		// 匿名结构体 字段合并到上级
+		if name == "" {
+			for fv.Kind() == reflect.Ptr {
+				key := redactVisit{fv.Pointer(), fv.Type()}
+				if fv.IsNil() || seen[key] {
+					break
+				}
+				seen[key] = true
+				defer delete(seen, key)
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				r.fields(fv, result, seen, depth+1)
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		if omitempty && isEmpty(fv) {
+			continue
+		}
+		if logTag == "mask" || match(r.keys, name) {
+			result[name] = r.Mask
+			continue
+		}
+		result[name] = r.value(fv, seen, depth+1)
+	}
+}
+
+// 叶子值 与 json 编码结果一致
+func (r *Redactor) leaf(v reflect.Value) interface{} {
+	if !v.CanInterface() {
+		return nil
+	}
+	data, err := json.Marshal(v.Interface())
+	if err != nil {
+		return nil
+	}
+	var result interface{}
+	json.Unmarshal(data, &result)
+	return result
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
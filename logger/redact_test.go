@@ -0,0 +1,68 @@
+package logger
+
+import (
+	"testing"
+)
+
+type (
+	redactNode struct {
+		Name     string      `json:"name"`
+		Password string      `json:"password"`
+		Next     *redactNode `json:"next"`
+	}
+
+	redactEmbed struct {
+		*redactEmbed
+		Name string `json:"name"`
+	}
+)
+
+func TestRedactCycle(t *testing.T) {
+	redactor := &Redactor{Keys: []string{"password"}}
+
+	node := &redactNode{Name: "a", Password: "secret"}
+	node.Next = node
+	if result := redactor.Value(node); result["name"] != "a" || result["password"] != "***" || result["next"] != "***" {
+		t.Fatalf("%v", result)
+	}
+
+	values := map[string]interface{}{"name": "a"}
+	values["self"] = values
+	values["list"] = []interface{}{values}
+	result := redactor.Value(values)
+	if list, _ := result["list"].([]interface{}); result["self"] != "***" || len(list) != 1 || list[0] != "***" {
+		t.Fatalf("%v", result)
+	}
+
+	embed := &redactEmbed{Name: "a"}
+	embed.redactEmbed = embed
+	if result := redactor.Value(embed); result["name"] != "a" {
+		t.Fatalf("%v", result)
+	}
+
+	// 同一个值出现多次 不是循环
+	shared := &redactNode{Name: "b"}
+	result = redactor.Value(map[string]interface{}{"x": shared, "y": shared})
+	if x, _ := result["x"].(map[string]interface{}); x["name"] != "b" {
+		t.Fatalf("%v", result)
+	}
+	if y, _ := result["y"].(map[string]interface{}); y["name"] != "b" {
+		t.Fatalf("%v", result)
+	}
+}
+
+func TestRedactDepth(t *testing.T) {
+	var node *redactNode
+	for i := 0; i < RedactMaxDepth*2; i++ {
+		node = &redactNode{Name: "a", Next: node}
+	}
+	result := DefaultRedactor.Value(node)
+	depth := 0
+	for val, ok := result["next"].(map[string]interface{}); ok; val, ok = val["next"].(map[string]interface{}) {
+		depth++
+		result = val
+	}
+	if depth != RedactMaxDepth || result["next"] != "***" {
+		t.Fatalf("depth %d %v", depth, result["next"])
+	}
+}
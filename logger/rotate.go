@@ -0,0 +1,216 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// 按大小 和 时间 切割日志文件
+	// 旧文件命名为 <Filename>.<时间>[.gz]  保留 MaxBackups 个
+	Rotate struct {
+		Filename   string
+		MaxSize    int64
+		Interval   time.Duration
+		MaxBackups int
+		Gzip       bool
+		Mode       os.FileMode
+
+		mutex  sync.Mutex
+		file   *os.File
+		size   int64
+		expire time.Time
+		wait   sync.WaitGroup
+	}
+)
+
+var RotateTimeFormat = "20060102T150405"
+
+func (r *Rotate) Write(p []byte) (n int, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		if err = r.open(); err != nil {
+			return
+		}
+	}
+	if (r.MaxSize > 0 && r.size != 0 && r.size+int64(len(p)) > r.MaxSize) || (!r.expire.IsZero() && !time.Now().Before(r.expire)) {
+		if err = r.rotate(); err != nil {
+			return
+		}
+	}
+	n, err = r.file.Write(p)
+	r.size += int64(n)
+	return
+}
+
+// 立即切割
+func (r *Rotate) Rotate() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return err
+		}
+	}
+	return r.rotate()
+}
+
+// 外部 logrotate 移走文件后 重新打开
+func (r *Rotate) Reopen() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	return r.open()
+}
+
+func (r *Rotate) Close() (err error) {
+	r.mutex.Lock()
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	r.mutex.Unlock()
+	r.wait.Wait()
+	return
+}
+
+func (r *Rotate) open() (err error) {
+	mode := r.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+	if err = os.MkdirAll(filepath.Dir(r.Filename), 0755); err != nil {
+		return
+	}
+	if r.file, err = os.OpenFile(r.Filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, mode); err != nil {
+		return
+	}
+	r.size = 0
+	opened := time.Now()
+	if stat, e := r.file.Stat(); e == nil {
+		r.size = stat.Size()
+		if r.size != 0 {
+			opened = stat.ModTime()
+		}
+	}
+	r.expire = time.Time{}
+	if r.Interval > 0 {
+		r.expire = opened.Truncate(r.Interval).Add(r.Interval)
+	}
+	return
+}
+
+func (r *Rotate) rotate() (err error) {
+	if err = r.file.Close(); err != nil {
+		return
+	}
+	r.file = nil
+
+	name := r.Filename + "." + time.Now().Format(RotateTimeFormat)
+	backup := name
+	for i := 1; exists(backup) || exists(backup+".gz"); i++ {
+		backup = name + "." + strconv.Itoa(i)
+	}
+	if err = os.Rename(r.Filename, backup); err != nil && !os.IsNotExist(err) {
+		return
+	}
+	if err = r.open(); err != nil {
+		return
+	}
+
+	// 压缩 和 清理 不阻塞写入
+	r.wait.Add(1)
+	go func() {
+		defer r.wait.Done()
+		if r.Gzip {
+			compressFile(backup)
+		}
+		r.prune()
+	}()
+	return
+}
+
+func (r *Rotate) prune() {
+	if r.MaxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(r.Filename + ".*")
+	if err != nil {
+		return
+	}
+	var backups []string
+	for _, val := range matches {
+		if strings.HasSuffix(val, ".tmp") {
+			continue
+		}
+		suffix := strings.TrimSuffix(val[len(r.Filename)+1:], ".gz")
+		if index := strings.Index(suffix, "."); index != -1 {
+			suffix = suffix[:index]
+		}
+		if _, err := time.Parse(RotateTimeFormat, suffix); err == nil {
+			backups = append(backups, val)
+		}
+	}
+	if len(backups) <= r.MaxBackups {
+		return
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backupKey(backups[i]) > backupKey(backups[j])
+	})
+	for _, val := range backups[r.MaxBackups:] {
+		os.Remove(val)
+	}
+}
+
+// 时间 和 序号 排序
+func backupKey(name string) string {
+	name = strings.TrimSuffix(name, ".gz")
+	ext := filepath.Ext(name)
+	if n, err := strconv.Atoi(strings.TrimPrefix(ext, ".")); err == nil {
+		return strings.TrimSuffix(name, ext) + "." + strings.Repeat("0", 6-len(strconv.Itoa(n))) + strconv.Itoa(n)
+	}
+	return name + ".000000"
+}
+
+func compressFile(name string) (err error) {
+	src, err := os.Open(name)
+	if err != nil {
+		return
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz.tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	writer := gzip.NewWriter(dst)
+	if _, err = io.Copy(writer, src); err == nil {
+		err = writer.Close()
+	}
+	if e := dst.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		os.Remove(name + ".gz.tmp")
+		return
+	}
+	if err = os.Rename(name+".gz.tmp", name+".gz"); err != nil {
+		return
+	}
+	return os.Remove(name)
+}
+
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
@@ -0,0 +1,109 @@
+package logger
+
+import (
+	"math/rand"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// 只对成功的请求采样  错误 5xx 慢请求 全部保留
+	// Routes 键为 Route() 结果 或 path.Match 模式
+	Sampling struct {
+		Rate   float64
+		Routes map[string]float64
+	}
+
+	// 记录首字节时间  写入前设置 header
+	// 没有 body 的响应 如 ServeContent 的 304  在 WriteHeader 时设置
+	timingWriter struct {
+		gin.ResponseWriter
+		firstByte time.Time
+		header    func(header http.Header)
+		written   bool
+	}
+)
+
+func (sampling *Sampling) RouteRate(route string) float64 {
+	if rate, ok := sampling.Routes[route]; ok {
+		return rate
+	}
+	for pattern, rate := range sampling.Routes {
+		if ok, _ := path.Match(pattern, route); ok {
+			return rate
+		}
+	}
+	return sampling.Rate
+}
+
+// 返回是否保留 和 采样率
+func (sampling *Sampling) Sample(route string) (bool, float64) {
+	rate := sampling.RouteRate(route)
+	if rate >= 1 {
+		return true, 1
+	}
+	return rand.Float64() < rate, rate
+}
+
+// 可以在其他 goroutine 调用  请求结束后忽略
+func (logger *Logger) AddTiming(name string, duration time.Duration) {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	if logger.finished {
+		return
+	}
+	logger.addTiming(name, duration)
+}
+
+func (logger *Logger) addTiming(name string, duration time.Duration) {
+	if logger.Timings == nil {
+		logger.Timings = map[string]time.Duration{}
+	}
+	logger.Timings[name] += duration
+}
+
+// 之后 logger 由中间件写入 并异步编码  不再接受 AddTiming
+func (logger *Logger) finish() {
+	logger.mutex.Lock()
+	logger.finished = true
+	logger.mutex.Unlock()
+}
+
+func (w *timingWriter) mark() {
+	if w.firstByte.IsZero() {
+		w.firstByte = time.Now()
+		w.writeHeader()
+	}
+}
+
+func (w *timingWriter) writeHeader() {
+	if w.header != nil && !w.written && !w.ResponseWriter.Written() {
+		w.written = true
+		w.header(w.ResponseWriter.Header())
+	}
+}
+
+func (w *timingWriter) WriteHeader(code int) {
+	w.writeHeader()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timingWriter) WriteHeaderNow() {
+	if !w.ResponseWriter.Written() {
+		w.mark()
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *timingWriter) Write(data []byte) (int, error) {
+	w.mark()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *timingWriter) WriteString(data string) (int, error) {
+	w.mark()
+	return w.ResponseWriter.WriteString(data)
+}
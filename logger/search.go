@@ -0,0 +1,247 @@
+package logger
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/errs"
+	ginResource "github.com/otamoe/gin-server/resource"
+	"github.com/otamoe/gin-server/scope"
+)
+
+type (
+	// 查询 Model  group 需要 mongo 中间件
+	Search struct {
+		// 默认通过 scope 验证权限  resource type 为 logger
+		// true 时不验证  任何人都可以查询所有日志
+		Public bool
+
+		// 默认 50  最大 500
+		Limit    int
+		MaxLimit int
+	}
+
+	SearchResult struct {
+		Items      []*Logger `json:"items"`
+		NextCursor string    `json:"next_cursor,omitempty"`
+	}
+)
+
+var ErrNotFound = &errs.Error{
+	Message:    http.StatusText(http.StatusNotFound),
+	Type:       "not_found",
+	StatusCode: http.StatusNotFound,
+}
+
+func (c *Search) init() {
+	if c.Limit == 0 {
+		c.Limit = 50
+	}
+	if c.MaxLimit == 0 {
+		c.MaxLimit = 500
+	}
+	if c.Limit > c.MaxLimit {
+		c.Limit = c.MaxLimit
+	}
+}
+
+// 注册路由
+// GET ""     ?user= &token= &resource_type= &resource_action= &resource_owner= &status_min= &status_max= &ip= &from= &to= &cursor= &limit=
+// GET "/:id"
+func (c Search) Register(group gin.IRoutes) {
+	c.init()
+	group.GET("", c.list)
+	group.GET("/:id", c.read)
+}
+
+func (c Search) list(ctx *gin.Context) {
+	var err error
+	defer func() {
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+		}
+	}()
+
+	var params map[string]interface{}
+	if params, err = c.authorize(ctx, "list", ""); err != nil {
+		return
+	}
+
+	var filter bson.M
+	if filter, err = c.filter(ctx, params); err != nil {
+		return
+	}
+
+	limit := c.Limit
+	if val := ctx.Query("limit"); val != "" {
+		if limit, err = strconv.Atoi(val); err != nil || limit < 1 {
+			err = invalid("limit", val)
+			return
+		}
+		if limit > c.MaxLimit {
+			limit = c.MaxLimit
+		}
+	}
+
+	// 多取一条 判断是否有下一页
+	result := SearchResult{Items: []*Logger{}}
+	if err = Model.DB(ctx).Find(filter).Sort("-_id").Limit(limit + 1).All(&result.Items); err != nil {
+		return
+	}
+	if len(result.Items) > limit {
+		result.Items = result.Items[:limit]
+		result.NextCursor = result.Items[limit-1].ID.Hex()
+	}
+	ctx.JSON(http.StatusOK, result)
+}
+
+func (c Search) read(ctx *gin.Context) {
+	var err error
+	defer func() {
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+		}
+	}()
+
+	id := ctx.Param("id")
+	var params map[string]interface{}
+	if params, err = c.authorize(ctx, "read", id); err != nil {
+		return
+	}
+	if !bson.IsObjectIdHex(id) {
+		err = ErrNotFound
+		return
+	}
+	document := &Logger{}
+	err = Model.DB(ctx).FindId(bson.ObjectIdHex(id)).One(document)
+	if err == mgo.ErrNotFound {
+		err = ErrNotFound
+		return
+	} else if err != nil {
+		return
+	}
+
+	if user, ok := params["user"].(bson.ObjectId); ok && user != document.UserID {
+		err = ErrNotFound
+		return
+	}
+	ctx.JSON(http.StatusOK, document)
+}
+
+// scope 返回的 params 中有 user 时 只能查询该用户的日志
+func (c Search) authorize(ctx *gin.Context, action string, value string) (params map[string]interface{}, err error) {
+	if c.Public {
+		return
+	}
+	resource := ctx.MustGet(ginResource.CONTEXT).(*ginResource.Resource)
+	if resource.Type == "" {
+		resource.Type = "logger"
+	}
+	if resource.Action == "" {
+		resource.Action = action
+	}
+	if resource.Value == "" {
+		resource.Value = value
+	}
+	params, err = scope.Validate(ctx)
+	return
+}
+
+func (c Search) filter(ctx *gin.Context, params map[string]interface{}) (filter bson.M, err error) {
+	filter = bson.M{}
+
+	for name, key := range map[string]string{"user": "user", "token": "token", "resource_owner": "resource.owner", "cursor": "_id"} {
+		val := ctx.Query(name)
+		if val == "" {
+			continue
+		}
+		if !bson.IsObjectIdHex(val) {
+			err = invalid(name, val)
+			return
+		}
+		if name == "cursor" {
+			filter[key] = bson.M{"$lt": bson.ObjectIdHex(val)}
+		} else {
+			filter[key] = bson.ObjectIdHex(val)
+		}
+	}
+	if user, ok := params["user"].(bson.ObjectId); ok {
+		filter["user"] = user
+	}
+
+	for name, key := range map[string]string{"resource_type": "resource.type", "resource_action": "resource.action", "ip": "ip"} {
+		if val := ctx.Query(name); val != "" {
+			filter[key] = val
+		}
+	}
+
+	status := bson.M{}
+	for name, operator := range map[string]string{"status_min": "$gte", "status_max": "$lte"} {
+		val := ctx.Query(name)
+		if val == "" {
+			continue
+		}
+		var code int
+		if code, err = strconv.Atoi(val); err != nil {
+			err = invalid(name, val)
+			return
+		}
+		status[operator] = code
+	}
+	if len(status) != 0 {
+		filter["status_code"] = status
+	}
+
+	createdAt := bson.M{}
+	for name, operator := range map[string]string{"from": "$gte", "to": "$lt"} {
+		val := ctx.Query(name)
+		if val == "" {
+			continue
+		}
+		var t time.Time
+		if t, err = parseTime(val); err != nil {
+			err = invalid(name, val)
+			return
+		}
+		createdAt[operator] = t
+	}
+	if len(createdAt) != 0 {
+		filter["created_at"] = createdAt
+	}
+	return
+}
+
+// RFC 3339 或 unix 秒
+func parseTime(val string) (t time.Time, err error) {
+	if sec, e := strconv.ParseInt(val, 10, 64); e == nil {
+		t = time.Unix(sec, 0)
+		return
+	}
+	t, err = time.Parse(time.RFC3339, val)
+	return
+}
+
+func invalid(name string, val string) error {
+	return &errs.Error{
+		Message:    "Invalid query parameter",
+		Type:       "logger",
+		Path:       name,
+		Value:      val,
+		StatusCode: http.StatusBadRequest,
+	}
+}
+
+// 按 created_at 过期  0 不过期  需要调用 Model.Update 同步索引
+func SetRetention(d time.Duration) {
+	for i, index := range Model.Indexs {
+		if len(index.Key) == 1 && index.Key[0] == "created_at" {
+			Model.Indexs[i].ExpireAfter = d
+		}
+	}
+}
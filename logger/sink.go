@@ -0,0 +1,144 @@
+package logger
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/otamoe/gin-server/mongo"
+	mgoModel "github.com/otamoe/mgo-model"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// 异步批量写入 Model  队列满时丢弃
+	Sink struct {
+		GetSession    mongo.GetSession
+		QueueSize     int
+		BatchSize     int
+		FlushInterval time.Duration
+		Logrus        *logrus.Logger
+
+		queue    chan *Logger
+		done     chan struct{}
+		dropped  uint64
+		reported uint64
+		once     sync.Once
+		mutex    sync.RWMutex
+		closed   bool
+	}
+)
+
+func (sink *Sink) Start() *Sink {
+	sink.once.Do(func() {
+		if sink.QueueSize == 0 {
+			sink.QueueSize = 4096
+		}
+		if sink.BatchSize == 0 {
+			sink.BatchSize = 256
+		}
+		if sink.FlushInterval == 0 {
+			sink.FlushInterval = time.Second * 2
+		}
+		if sink.Logrus == nil {
+			sink.Logrus = logrus.StandardLogger()
+		}
+		sink.queue = make(chan *Logger, sink.QueueSize)
+		sink.done = make(chan struct{})
+		go sink.run()
+	})
+	return sink
+}
+
+// 不阻塞  队列满或已关闭时丢弃
+func (sink *Sink) Push(logger *Logger) bool {
+	sink.mutex.RLock()
+	defer sink.mutex.RUnlock()
+	if sink.closed {
+		atomic.AddUint64(&sink.dropped, 1)
+		return false
+	}
+	select {
+	case sink.queue <- logger:
+		return true
+	default:
+		atomic.AddUint64(&sink.dropped, 1)
+		return false
+	}
+}
+
+func (sink *Sink) Dropped() uint64 {
+	return atomic.LoadUint64(&sink.dropped)
+}
+
+// 停止接收 写入队列中剩余的记录
+func (sink *Sink) Close(ctx context.Context) error {
+	sink.mutex.Lock()
+	if !sink.closed {
+		sink.closed = true
+		close(sink.queue)
+	}
+	sink.mutex.Unlock()
+	select {
+	case <-sink.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (sink *Sink) run() {
+	defer close(sink.done)
+	ticker := time.NewTicker(sink.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]interface{}, 0, sink.BatchSize)
+	for {
+		select {
+		case logger, ok := <-sink.queue:
+			if !ok {
+				sink.flush(batch)
+				return
+			}
+			batch = append(batch, logger)
+			if len(batch) >= sink.BatchSize {
+				sink.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			sink.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (sink *Sink) flush(batch []interface{}) {
+	if dropped := sink.Dropped(); dropped != sink.reported {
+		sink.Logrus.WithField("dropped", dropped-sink.reported).Warn("[LOGGER] sink queue is full")
+		sink.reported = dropped
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	session := sink.GetSession()
+	defer session.Close()
+	ctx := context.WithValue(context.Background(), mgoModel.CONTEXT, session)
+	collection := Model.DB(ctx)
+
+	// 无序写入  单条失败不影响其他记录
+	bulk := collection.Bulk()
+	bulk.Unordered()
+	bulk.Insert(batch...)
+	if _, err := bulk.Run(); err != nil {
+		if bulkErr, ok := err.(*mgo.BulkError); ok {
+			for _, val := range bulkErr.Cases() {
+				sink.Logrus.WithError(val.Err).Error("[LOGGER] sink insert")
+			}
+		} else {
+			sink.Logrus.WithError(err).WithField("count", len(batch)).Error("[LOGGER] sink insert")
+		}
+	}
+}
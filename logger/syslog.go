@@ -0,0 +1,325 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// RFC 5424  udp tcp unix unixgram
+	// 字段写入 structured data  异步发送 队列满时丢弃
+	Syslog struct {
+		Network  string
+		Address  string
+		Tag      string
+		Hostname string
+
+		// nil 为 user
+		Facility *int
+
+		// 连接超时 默认 5 秒  连接失败后等待 Retry 再重连 期间的记录丢弃
+		Timeout   time.Duration
+		Retry     time.Duration
+		QueueSize int
+
+		queue   chan []byte
+		done    chan struct{}
+		dropped uint64
+		retry   time.Time
+		once    sync.Once
+		mutex   sync.RWMutex
+		closed  bool
+		conn    net.Conn
+	}
+)
+
+// 结构化数据 ID  32473 为文档示例企业号
+var SyslogSDID = "fields@32473"
+
+var SyslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+func (hook *Syslog) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (hook *Syslog) Start() *Syslog {
+	hook.once.Do(func() {
+		if hook.Timeout == 0 {
+			hook.Timeout = time.Second * 5
+		}
+		if hook.Retry == 0 {
+			hook.Retry = time.Second * 5
+		}
+		if hook.QueueSize == 0 {
+			hook.QueueSize = 4096
+		}
+		hook.queue = make(chan []byte, hook.QueueSize)
+		hook.done = make(chan struct{})
+		go hook.run()
+	})
+	return hook
+}
+
+// 不阻塞  队列满或已关闭时丢弃
+func (hook *Syslog) Fire(entry *logrus.Entry) error {
+	hook.Start()
+	message := hook.format(entry)
+
+	hook.mutex.RLock()
+	defer hook.mutex.RUnlock()
+	if hook.closed {
+		atomic.AddUint64(&hook.dropped, 1)
+		return nil
+	}
+	select {
+	case hook.queue <- message:
+	default:
+		atomic.AddUint64(&hook.dropped, 1)
+	}
+	return nil
+}
+
+func (hook *Syslog) Dropped() uint64 {
+	return atomic.LoadUint64(&hook.dropped)
+}
+
+// 停止接收 发送队列中剩余的记录
+func (hook *Syslog) Close(ctx context.Context) error {
+	hook.Start()
+	hook.mutex.Lock()
+	if !hook.closed {
+		hook.closed = true
+		close(hook.queue)
+	}
+	hook.mutex.Unlock()
+	select {
+	case <-hook.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (hook *Syslog) run() {
+	defer close(hook.done)
+	for message := range hook.queue {
+		if !hook.send(message) {
+			atomic.AddUint64(&hook.dropped, 1)
+		}
+	}
+	if hook.conn != nil {
+		hook.conn.Close()
+		hook.conn = nil
+	}
+}
+
+// 断开后重连一次
+func (hook *Syslog) send(message []byte) bool {
+	for i := 0; i < 2; i++ {
+		if hook.conn == nil {
+			if time.Now().Before(hook.retry) {
+				return false
+			}
+			if err := hook.dial(); err != nil {
+				hook.retry = time.Now().Add(hook.Retry)
+				return false
+			}
+		}
+		if err := hook.write(message); err == nil {
+			return true
+		}
+		hook.conn.Close()
+		hook.conn = nil
+	}
+	return false
+}
+
+func (hook *Syslog) dial() (err error) {
+	network := hook.Network
+	address := hook.Address
+	timeout := hook.Timeout
+	switch network {
+	case "", "udp":
+		network = "udp"
+		if address == "" {
+			address = "localhost:514"
+		}
+	case "tcp":
+		if address == "" {
+			address = "localhost:514"
+		}
+	case "unix", "unixgram":
+		if address == "" {
+			address = "/dev/log"
+		}
+	}
+	hook.conn, err = net.DialTimeout(network, address, timeout)
+	if err != nil && network == "unix" {
+		// /dev/log 通常是 datagram
+		hook.conn, err = net.DialTimeout("unixgram", address, timeout)
+	}
+	return
+}
+
+func (hook *Syslog) write(message []byte) (err error) {
+	switch hook.conn.(type) {
+	case *net.TCPConn:
+		// RFC 6587 octet counting
+		_, err = hook.conn.Write(append([]byte(strconv.Itoa(len(message))+" "), message...))
+	case *net.UnixConn:
+		if hook.conn.RemoteAddr() != nil && hook.conn.RemoteAddr().Network() == "unix" {
+			_, err = hook.conn.Write(append([]byte(strconv.Itoa(len(message))+" "), message...))
+		} else {
+			_, err = hook.conn.Write(message)
+		}
+	default:
+		_, err = hook.conn.Write(message)
+	}
+	return
+}
+
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG
+func (hook *Syslog) format(entry *logrus.Entry) []byte {
+	facility := SyslogFacilities["user"]
+	if hook.Facility != nil {
+		facility = *hook.Facility
+	}
+	hostname := hook.Hostname
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	tag := hook.Tag
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+	msgid := "-"
+	if NewAccess(entry) != nil {
+		msgid = "access"
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "<%d>1 %s %s %s %d %s ",
+		facility*8+severity(entry.Level),
+		entry.Time.Format(time.RFC3339Nano),
+		sdHeader(hostname, 255),
+		sdHeader(tag, 48),
+		os.Getpid(),
+		msgid,
+	)
+
+	if len(entry.Data) == 0 {
+		buf.WriteByte('-')
+	} else {
+		keys := make([]string, 0, len(entry.Data))
+		for key := range entry.Data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf.WriteString("[" + SyslogSDID)
+		for _, key := range keys {
+			name := sdName(key)
+			if name == "" {
+				continue
+			}
+			buf.WriteString(" " + name + "=\"" + sdValue(fieldString(entry.Data[key])) + "\"")
+		}
+		buf.WriteByte(']')
+	}
+	buf.WriteByte(' ')
+	buf.WriteString(entry.Message)
+	return buf.Bytes()
+}
+
+// logrus 级别 转为 syslog severity
+func severity(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel:
+		return 0
+	case logrus.FatalLevel:
+		return 2
+	case logrus.ErrorLevel:
+		return 3
+	case logrus.WarnLevel:
+		return 4
+	case logrus.InfoLevel:
+		return 6
+	default:
+		return 7
+	}
+}
+
+func fieldString(val interface{}) string {
+	switch val := val.(type) {
+	case string:
+		return val
+	case error:
+		return val.Error()
+	case fmt.Stringer:
+		return val.String()
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// 可打印 ASCII  不含空格
+func sdHeader(val string, max int) string {
+	result := make([]byte, 0, len(val))
+	for i := 0; i < len(val) && len(result) < max; i++ {
+		if val[i] > 32 && val[i] < 127 {
+			result = append(result, val[i])
+		}
+	}
+	if len(result) == 0 {
+		return "-"
+	}
+	return string(result)
+}
+
+// SD-NAME 不能包含 = 空格 ] "
+func sdName(val string) string {
+	result := make([]byte, 0, len(val))
+	for i := 0; i < len(val) && len(result) < 32; i++ {
+		c := val[i]
+		if c > 32 && c < 127 && c != '=' && c != ']' && c != '"' {
+			result = append(result, c)
+		}
+	}
+	return string(result)
+}
+
+func sdValue(val string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(val)
+}
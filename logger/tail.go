@@ -0,0 +1,390 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	ginResource "github.com/otamoe/gin-server/resource"
+	"github.com/otamoe/gin-server/scope"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// 实时日志  设置 Redis 时通过 pub/sub 广播到所有实例
+	// 所有实例都没有订阅者时不发送
+	Broadcaster struct {
+		Redis     *redis.Client
+		Channel   string
+		QueueSize int
+		Buffer    int
+		Logrus    *logrus.Logger
+
+		// 检查其他实例是否有订阅者的间隔 默认 5 秒
+		Interval time.Duration
+
+		subscribers map[*Subscriber]struct{}
+		queue       chan *Logger
+		done        chan struct{}
+		stop        chan struct{}
+		active      int32
+		pubsub      *redis.PubSub
+		once        sync.Once
+		mutex       sync.RWMutex
+		closed      bool
+	}
+
+	Subscriber struct {
+		Filter TailFilter
+		C      chan *Logger
+
+		dropped uint64
+	}
+
+	TailFilter struct {
+		Handler   string
+		StatusMin int
+		StatusMax int
+		Path      string
+		UserID    bson.ObjectId
+	}
+
+	// Server-Sent Events
+	Tail struct {
+		Broadcaster *Broadcaster
+
+		// 默认通过 scope 验证权限  resource type 为 logger
+		// true 时不验证  任何人都可以订阅所有日志
+		Public bool
+
+		// 心跳间隔 默认 15 秒
+		Heartbeat time.Duration
+
+		// 单次连接时长 默认 25 秒  需要小于 server WriteTimeout  EventSource 会自动重连
+		Duration time.Duration
+	}
+)
+
+var TailChannel = "gin.server.logger"
+
+func (broadcaster *Broadcaster) Start() *Broadcaster {
+	broadcaster.once.Do(func() {
+		if broadcaster.Channel == "" {
+			broadcaster.Channel = TailChannel
+		}
+		if broadcaster.QueueSize == 0 {
+			broadcaster.QueueSize = 1024
+		}
+		if broadcaster.Buffer == 0 {
+			broadcaster.Buffer = 64
+		}
+		if broadcaster.Logrus == nil {
+			broadcaster.Logrus = logrus.StandardLogger()
+		}
+		if broadcaster.Interval == 0 {
+			broadcaster.Interval = time.Second * 5
+		}
+		broadcaster.subscribers = map[*Subscriber]struct{}{}
+		broadcaster.done = make(chan struct{})
+		if broadcaster.Redis == nil {
+			close(broadcaster.done)
+			return
+		}
+		broadcaster.queue = make(chan *Logger, broadcaster.QueueSize)
+		broadcaster.stop = make(chan struct{})
+		broadcaster.pubsub = broadcaster.Redis.Subscribe(broadcaster.Channel)
+		go broadcaster.publish()
+		go broadcaster.receive()
+		go broadcaster.watch()
+	})
+	return broadcaster
+}
+
+// 不阻塞  没有 Redis 时直接分发到本地订阅者  编码和发送在 publish goroutine
+func (broadcaster *Broadcaster) Publish(logger *Logger) {
+	broadcaster.Start()
+	broadcaster.mutex.RLock()
+	defer broadcaster.mutex.RUnlock()
+	if broadcaster.closed {
+		return
+	}
+	if broadcaster.queue == nil {
+		broadcaster.dispatch(logger)
+		return
+	}
+	if len(broadcaster.subscribers) == 0 && atomic.LoadInt32(&broadcaster.active) == 0 {
+		return
+	}
+	select {
+	case broadcaster.queue <- logger:
+	default:
+	}
+}
+
+func (broadcaster *Broadcaster) Subscribe(filter TailFilter) *Subscriber {
+	broadcaster.Start()
+	subscriber := &Subscriber{
+		Filter: filter,
+		C:      make(chan *Logger, broadcaster.Buffer),
+	}
+	broadcaster.mutex.Lock()
+	broadcaster.subscribers[subscriber] = struct{}{}
+	broadcaster.mutex.Unlock()
+	if broadcaster.Redis != nil {
+		broadcaster.refresh()
+	}
+	return subscriber
+}
+
+func (broadcaster *Broadcaster) Unsubscribe(subscriber *Subscriber) {
+	broadcaster.mutex.Lock()
+	delete(broadcaster.subscribers, subscriber)
+	broadcaster.mutex.Unlock()
+}
+
+func (broadcaster *Broadcaster) Close(ctx context.Context) error {
+	broadcaster.Start()
+	broadcaster.mutex.Lock()
+	if !broadcaster.closed {
+		broadcaster.closed = true
+		if broadcaster.queue != nil {
+			close(broadcaster.queue)
+			close(broadcaster.stop)
+		}
+	}
+	broadcaster.mutex.Unlock()
+	select {
+	case <-broadcaster.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if broadcaster.pubsub != nil {
+		return broadcaster.pubsub.Close()
+	}
+	return nil
+}
+
+// 需要持有读锁
+func (broadcaster *Broadcaster) dispatch(logger *Logger) {
+	for subscriber := range broadcaster.subscribers {
+		if !subscriber.Filter.Match(logger) {
+			continue
+		}
+		select {
+		case subscriber.C <- logger:
+		default:
+			atomic.AddUint64(&subscriber.dropped, 1)
+		}
+	}
+}
+
+func (broadcaster *Broadcaster) publish() {
+	defer close(broadcaster.done)
+	for logger := range broadcaster.queue {
+		data, err := json.Marshal(logger)
+		if err != nil {
+			continue
+		}
+		if err = broadcaster.Redis.Publish(broadcaster.Channel, data).Err(); err != nil {
+			broadcaster.Logrus.WithError(err).Error("[LOGGER] tail publish")
+		}
+	}
+}
+
+func (broadcaster *Broadcaster) watch() {
+	ticker := time.NewTicker(broadcaster.Interval)
+	defer ticker.Stop()
+	for {
+		broadcaster.refresh()
+		select {
+		case <-ticker.C:
+		case <-broadcaster.stop:
+			return
+		}
+	}
+}
+
+// 有本地订阅者的实例 续期 Channel.subscribers  其他实例根据该 key 判断是否发送
+func (broadcaster *Broadcaster) refresh() {
+	key := broadcaster.Channel + ".subscribers"
+	broadcaster.mutex.RLock()
+	local := len(broadcaster.subscribers)
+	broadcaster.mutex.RUnlock()
+	if local != 0 {
+		atomic.StoreInt32(&broadcaster.active, 1)
+		if err := broadcaster.Redis.Set(key, 1, broadcaster.Interval*3).Err(); err != nil {
+			broadcaster.Logrus.WithError(err).Error("[LOGGER] tail refresh")
+		}
+		return
+	}
+	n, err := broadcaster.Redis.Exists(key).Result()
+	if err != nil {
+		broadcaster.Logrus.WithError(err).Error("[LOGGER] tail refresh")
+		return
+	}
+	if n != 0 {
+		atomic.StoreInt32(&broadcaster.active, 1)
+	} else {
+		atomic.StoreInt32(&broadcaster.active, 0)
+	}
+}
+
+func (broadcaster *Broadcaster) receive() {
+	for message := range broadcaster.pubsub.Channel() {
+		logger := &Logger{}
+		if err := json.Unmarshal([]byte(message.Payload), logger); err != nil {
+			continue
+		}
+		broadcaster.mutex.RLock()
+		broadcaster.dispatch(logger)
+		broadcaster.mutex.RUnlock()
+	}
+}
+
+// 订阅者缓冲区满时丢弃的记录数
+func (subscriber *Subscriber) Dropped() uint64 {
+	return atomic.LoadUint64(&subscriber.dropped)
+}
+
+func (filter TailFilter) Match(logger *Logger) bool {
+	if filter.Handler != "" && filter.Handler != logger.Handler {
+		return false
+	}
+	if filter.StatusMin != 0 && logger.StatusCode < filter.StatusMin {
+		return false
+	}
+	if filter.StatusMax != 0 && logger.StatusCode > filter.StatusMax {
+		return false
+	}
+	if filter.Path != "" && !strings.HasPrefix(logger.Path, filter.Path) {
+		return false
+	}
+	if filter.UserID != "" && filter.UserID != logger.UserID {
+		return false
+	}
+	return true
+}
+
+func (c *Tail) init() {
+	if c.Heartbeat == 0 {
+		c.Heartbeat = time.Second * 15
+	}
+	if c.Duration == 0 {
+		c.Duration = time.Second * 25
+	}
+	c.Broadcaster.Start()
+}
+
+// 注册路由
+// GET ""  ?handler= &status_min= &status_max= &path= &user=
+func (c Tail) Register(group gin.IRoutes) {
+	c.init()
+	group.GET("", c.stream)
+}
+
+func (c Tail) stream(ctx *gin.Context) {
+	var err error
+	defer func() {
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+		}
+	}()
+
+	var params map[string]interface{}
+	if params, err = c.authorize(ctx); err != nil {
+		return
+	}
+
+	var filter TailFilter
+	if filter, err = c.filter(ctx, params); err != nil {
+		return
+	}
+
+	subscriber := c.Broadcaster.Subscribe(filter)
+	defer c.Broadcaster.Unsubscribe(subscriber)
+
+	header := ctx.Writer.Header()
+	header.Set("Content-Type", "text/event-stream; charset=utf-8")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	ctx.Status(http.StatusOK)
+	fmt.Fprintf(ctx.Writer, "retry: %d\n\n", 1000)
+	ctx.Writer.Flush()
+
+	heartbeat := time.NewTicker(c.Heartbeat)
+	defer heartbeat.Stop()
+	timeout := time.NewTimer(c.Duration)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case <-timeout.C:
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(ctx.Writer, ": ping\n\n")
+		case logger := <-subscriber.C:
+			data, e := json.Marshal(logger)
+			if e != nil {
+				continue
+			}
+			fmt.Fprintf(ctx.Writer, "id: %s\nevent: logger\ndata: %s\n\n", logger.ID.Hex(), data)
+		}
+		ctx.Writer.Flush()
+	}
+}
+
+// scope 返回的 params 中有 user 时 只能订阅该用户的日志
+func (c Tail) authorize(ctx *gin.Context) (params map[string]interface{}, err error) {
+	if c.Public {
+		return
+	}
+	resource := ctx.MustGet(ginResource.CONTEXT).(*ginResource.Resource)
+	if resource.Type == "" {
+		resource.Type = "logger"
+	}
+	if resource.Action == "" {
+		resource.Action = "tail"
+	}
+	params, err = scope.Validate(ctx)
+	return
+}
+
+func (c Tail) filter(ctx *gin.Context, params map[string]interface{}) (filter TailFilter, err error) {
+	filter.Handler = ctx.Query("handler")
+	filter.Path = ctx.Query("path")
+	if val := ctx.Query("user"); val != "" {
+		if !bson.IsObjectIdHex(val) {
+			err = invalid("user", val)
+			return
+		}
+		filter.UserID = bson.ObjectIdHex(val)
+	}
+	if user, ok := params["user"].(bson.ObjectId); ok {
+		filter.UserID = user
+	}
+	if val := ctx.Query("status_min"); val != "" {
+		if filter.StatusMin, err = strconv.Atoi(val); err != nil {
+			err = invalid("status_min", val)
+			return
+		}
+	}
+	if val := ctx.Query("status_max"); val != "" {
+		if filter.StatusMax, err = strconv.Atoi(val); err != nil {
+			err = invalid("status_max", val)
+			return
+		}
+	}
+	return
+}
@@ -64,8 +64,13 @@ func (config *Mongo) init(server *Server, handler *Handler) {
 		}
 	}
 
-	var err error
-	if config.session, err = mgo.DialWithTimeout(strings.Join(config.URLs, ","), config.DialTimeout); err != nil {
+	info, err := mgo.ParseURL(strings.Join(config.URLs, ","))
+	if err != nil {
+		panic(err)
+	}
+	info.Timeout = config.DialTimeout
+	mongo.Instrument(info)
+	if config.session, err = mgo.DialWithInfo(info); err != nil {
 		panic(err)
 	}
 	config.session.SetPoolLimit(config.PoolLimit)
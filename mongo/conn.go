@@ -0,0 +1,351 @@
+package mongo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+type (
+	// 解析经过连接的 wire protocol 记录每个操作  Middleware 按 session 持有的连接取回
+	// 写入在调用 mgo 的 goroutine  读取在 mgo 的 readLoop goroutine
+	Conn struct {
+		net.Conn
+
+		mutex   sync.Mutex
+		pending map[int32]*event
+		events  []*event
+
+		// 读取中的回复
+		reply []byte
+		skip  int
+	}
+
+	event struct {
+		operation  string
+		collection string
+		command    bool
+		start      time.Time
+		end        time.Time
+		err        error
+	}
+)
+
+const (
+	opReply       = 1
+	opUpdate      = 2001
+	opInsert      = 2002
+	opQuery       = 2004
+	opGetMore     = 2005
+	opDelete      = 2006
+	replyHeader   = 36
+	replyFailure  = 2
+	maxReplyParse = 64 * 1024
+)
+
+// 每个连接最多保留的未取回操作  不经过 Middleware 的 session 也会使用连接
+var MaxEvents = 256
+
+// 设置 DialServer  通过这些连接的操作会记录 span 和 请求统计
+// 在 mgo.DialWithInfo 之前调用
+func Instrument(info *mgo.DialInfo) {
+	dial := info.DialServer
+	timeout := info.Timeout
+	info.DialServer = func(addr *mgo.ServerAddr) (conn net.Conn, err error) {
+		if dial != nil {
+			conn, err = dial(addr)
+		} else if conn, err = net.DialTimeout("tcp", addr.TCPAddr().String(), timeout); err == nil {
+			conn.(*net.TCPConn).SetKeepAlive(true)
+		}
+		if err != nil {
+			return
+		}
+		return &Conn{
+			Conn:    conn,
+			pending: map[int32]*event{},
+		}, nil
+	}
+}
+
+// mgo 每次写入完整的消息  可能有多个
+func (conn *Conn) Write(b []byte) (n int, err error) {
+	start := time.Now()
+	// -1 为没有回复
+	var events []*event
+	var requests []int64
+	var write *event
+	for data := b; len(data) >= 16; {
+		length := int(int32(binary.LittleEndian.Uint32(data)))
+		if length < 16 || length > len(data) {
+			break
+		}
+		requestID := int32(binary.LittleEndian.Uint32(data[4:]))
+		ev := parseMessage(int32(binary.LittleEndian.Uint32(data[12:])), data[16:length])
+		data = data[length:]
+		if ev == nil {
+			continue
+		}
+		ev.start = start
+		if !ev.command && ev.operation != "find" && ev.operation != "getMore" {
+			// 旧的写操作没有回复  跟随的 getLastError 计入该操作
+			if write != nil {
+				events = append(events, write)
+				requests = append(requests, -1)
+			}
+			write = ev
+			continue
+		}
+		if write != nil && strings.EqualFold(ev.operation, "getLastError") {
+			write.command = true
+			ev = write
+			write = nil
+		}
+		events = append(events, ev)
+		requests = append(requests, int64(requestID))
+	}
+	if write != nil {
+		events = append(events, write)
+		requests = append(requests, -1)
+	}
+
+	n, err = conn.Conn.Write(b)
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	for i, ev := range events {
+		switch {
+		case err != nil:
+			ev.end = time.Now()
+			ev.err = err
+			conn.finish(ev)
+		case requests[i] == -1:
+			ev.end = start
+			conn.finish(ev)
+		default:
+			conn.pending[int32(requests[i])] = ev
+		}
+	}
+	return
+}
+
+func (conn *Conn) Read(b []byte) (n int, err error) {
+	n, err = conn.Conn.Read(b)
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.read(b[:n])
+	if err != nil {
+		for requestID, ev := range conn.pending {
+			ev.end = time.Now()
+			ev.err = err
+			conn.finish(ev)
+			delete(conn.pending, requestID)
+		}
+	}
+	return
+}
+
+// 回复头 36 字节  命令的回复再读取第一个文档 判断 ok
+func (conn *Conn) read(data []byte) {
+	for len(data) != 0 {
+		if conn.skip != 0 {
+			k := conn.skip
+			if k > len(data) {
+				k = len(data)
+			}
+			conn.skip -= k
+			data = data[k:]
+			continue
+		}
+		if data = conn.take(data, replyHeader); len(conn.reply) < replyHeader {
+			return
+		}
+		total := int(int32(binary.LittleEndian.Uint32(conn.reply)))
+		responseTo := int32(binary.LittleEndian.Uint32(conn.reply[8:]))
+		flags := binary.LittleEndian.Uint32(conn.reply[16:])
+		ev := conn.pending[responseTo]
+
+		var doc []byte
+		if ev != nil && ev.command && total >= replyHeader+4 {
+			if data = conn.take(data, replyHeader+4); len(conn.reply) < replyHeader+4 {
+				return
+			}
+			size := int(int32(binary.LittleEndian.Uint32(conn.reply[replyHeader:])))
+			if size >= 5 && size <= maxReplyParse && replyHeader+size <= total {
+				if data = conn.take(data, replyHeader+size); len(conn.reply) < replyHeader+size {
+					return
+				}
+				doc = conn.reply[replyHeader:]
+			}
+		}
+
+		if ev != nil {
+			delete(conn.pending, responseTo)
+			ev.end = time.Now()
+			if int32(binary.LittleEndian.Uint32(conn.reply[12:])) != opReply {
+				ev.err = errors.New("mongo: unexpected reply")
+			} else if flags&replyFailure != 0 {
+				ev.err = errors.New("mongo: query failure")
+			} else if doc != nil {
+				ev.err = replyError(doc)
+			}
+			conn.finish(ev)
+		}
+		if total > len(conn.reply) {
+			conn.skip = total - len(conn.reply)
+		}
+		conn.reply = conn.reply[:0]
+	}
+}
+
+func (conn *Conn) take(data []byte, size int) []byte {
+	if k := size - len(conn.reply); k > 0 {
+		if k > len(data) {
+			k = len(data)
+		}
+		conn.reply = append(conn.reply, data[:k]...)
+		data = data[k:]
+	}
+	return data
+}
+
+// 需要持有锁
+func (conn *Conn) finish(ev *event) {
+	if len(conn.events) >= MaxEvents {
+		conn.events = append(conn.events[:0], conn.events[len(conn.events)/2:]...)
+	}
+	conn.events = append(conn.events, ev)
+}
+
+// 取回 since 之后开始的操作  之前的丢弃
+func (conn *Conn) claim(since time.Time) (events []*event) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	for _, ev := range conn.events {
+		if !ev.start.Before(since) {
+			events = append(events, ev)
+		}
+	}
+	conn.events = conn.events[:0]
+	return
+}
+
+func (ev *event) name() string {
+	if ev.collection == "" {
+		return ev.operation
+	}
+	return ev.operation + " " + ev.collection
+}
+
+func parseMessage(opCode int32, body []byte) *event {
+	switch opCode {
+	case opQuery:
+		if len(body) < 4 {
+			return nil
+		}
+		name, rest := cstring(body[4:])
+		if len(rest) < 8 {
+			return nil
+		}
+		if strings.HasSuffix(name, ".$cmd") {
+			operation, collection := command(rest[8:])
+			if operation == "" {
+				return nil
+			}
+			return &event{operation: operation, collection: collection, command: true}
+		}
+		return &event{operation: "find", collection: collectionName(name)}
+	case opGetMore:
+		return legacy("getMore", body)
+	case opInsert:
+		return legacy("insert", body)
+	case opUpdate:
+		return legacy("update", body)
+	case opDelete:
+		return legacy("delete", body)
+	}
+	return nil
+}
+
+func legacy(operation string, body []byte) *event {
+	if len(body) < 4 {
+		return nil
+	}
+	name, _ := cstring(body[4:])
+	return &event{operation: operation, collection: collectionName(name)}
+}
+
+// 第一个键为命令名 值为集合名  $query 包裹时取内层
+func command(doc []byte) (operation string, collection string) {
+	if len(doc) < 5 {
+		return
+	}
+	elem := doc[4:]
+	kind := elem[0]
+	operation, value := cstring(elem[1:])
+	switch {
+	case kind == 0x03 && operation == "$query":
+		return command(value)
+	case kind == 0x02 && len(value) > 4:
+		collection, _ = cstring(value[4:])
+	}
+	return
+}
+
+func cstring(b []byte) (string, []byte) {
+	i := bytes.IndexByte(b, 0)
+	if i == -1 {
+		return "", nil
+	}
+	return string(b[:i]), b[i+1:]
+}
+
+// db.collection
+func collectionName(name string) string {
+	if i := strings.IndexByte(name, '.'); i != -1 {
+		return name[i+1:]
+	}
+	return name
+}
+
+func replyError(doc []byte) error {
+	var reply struct {
+		OK          interface{} `bson:"ok"`
+		Errmsg      string      `bson:"errmsg"`
+		Err         interface{} `bson:"err"`
+		WriteErrors []struct {
+			Errmsg string `bson:"errmsg"`
+		} `bson:"writeErrors"`
+	}
+	if bson.Unmarshal(doc, &reply) != nil {
+		return nil
+	}
+	switch ok := reply.OK.(type) {
+	case float64:
+		if ok == 0 {
+			return errors.New(reply.Errmsg)
+		}
+	case int:
+		if ok == 0 {
+			return errors.New(reply.Errmsg)
+		}
+	case bool:
+		if !ok {
+			return errors.New(reply.Errmsg)
+		}
+	}
+	if len(reply.WriteErrors) != 0 {
+		return errors.New(reply.WriteErrors[0].Errmsg)
+	}
+	if reply.Err != nil {
+		return errors.New(fmt.Sprint(reply.Err))
+	}
+	return nil
+}
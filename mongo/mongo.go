@@ -1,8 +1,14 @@
 package mongo
 
 import (
+	"context"
+	"sync"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/globalsign/mgo"
+	"github.com/otamoe/gin-server/stats"
+	"github.com/otamoe/gin-server/trace"
 )
 
 type (
@@ -11,11 +17,56 @@ type (
 
 var CONTEXT = "GIN.SERVER.MONGO"
 
+// 通过 Instrument 建立的连接  session 持有的连接上的操作记录为子 span 和 请求统计
 func Middleware(getSession GetSession) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		session := getSession()
 		defer session.Close()
+
+		// Server-Timing 在响应头写入时读取统计  需要先汇总
+		var mutex sync.Mutex
+		var closed bool
+		start := time.Now()
+		flush := func() {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if !closed {
+				record(ctx, session, start)
+			}
+		}
+		stats.FromContext(ctx).AddSource(flush)
+
 		ctx.Set(CONTEXT, session)
 		ctx.Next()
+		flush()
+		mutex.Lock()
+		closed = true
+		mutex.Unlock()
+	}
+}
+
+func record(ctx context.Context, session *mgo.Session, start time.Time) {
+	parent := trace.FromContext(ctx)
+	collection := stats.FromContext(ctx)
+	for _, sc := range sessionConns(session) {
+		since := start
+		if sc.since.After(since) {
+			since = sc.since
+		}
+		for _, ev := range sc.conn.claim(since) {
+			collection.Add("mongo", ev.name(), ev.end.Sub(ev.start), ev.err)
+			span := parent.Child("mongodb "+ev.name(), trace.KindClient)
+			if span == nil {
+				continue
+			}
+			span.Start = ev.start
+			span.SetAttribute("db.system", "mongodb")
+			span.SetAttribute("db.operation", ev.operation)
+			if ev.collection != "" {
+				span.SetAttribute("db.mongodb.collection", ev.collection)
+			}
+			span.SetError(ev.err)
+			span.FinishAt(ev.end)
+		}
 	}
 }
@@ -0,0 +1,195 @@
+package mongo
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/stats"
+	"github.com/otamoe/gin-server/trace"
+)
+
+// 只回复 OP_QUERY 的 mongod  wire version 2
+func testServer(t *testing.T) *mgo.Session {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+
+	info := &mgo.DialInfo{
+		Addrs:   []string{listener.Addr().String()},
+		Timeout: time.Second * 2,
+		Direct:  true,
+	}
+	Instrument(info)
+	session, err := mgo.DialWithInfo(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		session.Close()
+		listener.Close()
+	})
+	return session
+}
+
+func serve(conn net.Conn) {
+	defer conn.Close()
+	header := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		body := make([]byte, binary.LittleEndian.Uint32(header)-16)
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		if binary.LittleEndian.Uint32(header[12:]) != opQuery {
+			continue
+		}
+		name, rest := cstring(body[4:])
+		var doc bson.M = bson.M{"_id": 1}
+		if strings.HasSuffix(name, ".$cmd") {
+			switch operation, _ := command(rest[8:]); operation {
+			case "isMaster":
+				doc = bson.M{"ismaster": true, "maxWireVersion": 2, "ok": 1}
+			case "getnonce":
+				doc = bson.M{"nonce": "2375531c32080ae8", "ok": 1}
+			case "fail":
+				doc = bson.M{"ok": 0, "errmsg": "boom"}
+			default:
+				doc = bson.M{"ok": 1, "n": 1}
+			}
+		}
+		data, _ := bson.Marshal(doc)
+		reply := make([]byte, replyHeader, replyHeader+len(data))
+		binary.LittleEndian.PutUint32(reply, uint32(replyHeader+len(data)))
+		copy(reply[8:], header[4:8])
+		binary.LittleEndian.PutUint32(reply[12:], opReply)
+		binary.LittleEndian.PutUint32(reply[32:], 1)
+		conn.Write(append(reply, data...))
+	}
+}
+
+// 每个请求一个 trace  根 span 名为 ?c=
+func testEngine(session *mgo.Session, tracer *trace.Tracer, handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(func(ctx *gin.Context) {
+		span := tracer.Start(trace.SpanContext{}, ctx.Query("c"), trace.KindServer)
+		ctx.Set(trace.CONTEXT, span)
+		ctx.Set(stats.CONTEXT, &stats.Collection{})
+		ctx.Next()
+		span.Finish()
+	}, Middleware(session.Clone))
+	engine.GET("/", handler)
+	return engine
+}
+
+// 握手 如 getnonce 计入创建连接的请求  这里只比较业务操作
+func spans(memory *trace.Memory) map[string][]*trace.Span {
+	roots := map[trace.TraceID]string{}
+	for _, span := range memory.Spans() {
+		if span.Kind == trace.KindServer {
+			roots[span.Context.TraceID] = span.Name
+		}
+	}
+	result := map[string][]*trace.Span{}
+	for _, span := range memory.Spans() {
+		if span.Kind != trace.KindServer && span.Name != "mongodb getnonce" && span.Name != "mongodb isMaster" {
+			root := roots[span.Context.TraceID]
+			result[root] = append(result[root], span)
+		}
+	}
+	return result
+}
+
+func TestMiddlewareSpans(t *testing.T) {
+	memory := &trace.Memory{}
+	engine := testEngine(testServer(t), &trace.Tracer{Exporter: memory}, func(ctx *gin.Context) {
+		session := ctx.MustGet(CONTEXT).(*mgo.Session)
+		var doc bson.M
+		if err := session.DB("test").C("users").Find(nil).One(&doc); err != nil {
+			t.Error(err)
+		}
+		if err := session.DB("test").C("users").Insert(bson.M{"a": 1}); err != nil {
+			t.Error(err)
+		}
+		if err := session.DB("test").Run("fail", nil); err == nil {
+			t.Error("fail")
+		}
+
+		// 请求结束前读取  如 Server-Timing
+		mongo := stats.FromContext(ctx).Stats()["mongo"]
+		if mongo.Count < 3 || mongo.Errors != 1 {
+			t.Errorf("%+v", mongo)
+		}
+	})
+	engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/?c=root", nil))
+
+	var names []string
+	for _, span := range spans(memory)["root"] {
+		names = append(names, span.Name)
+		if span.End.Before(span.Start) || span.Kind != trace.KindClient {
+			t.Fatalf("%s %v %v", span.Name, span.Start, span.End)
+		}
+		if (span.Status == trace.StatusError) != (span.Name == "mongodb fail") {
+			t.Fatalf("%s status %d %s", span.Name, span.Status, span.StatusMessage)
+		}
+	}
+	if strings.Join(names, ",") != "mongodb find users,mongodb insert users,mongodb fail" {
+		t.Fatal(names)
+	}
+}
+
+// 并发请求的操作 只计入各自的请求
+func TestMiddlewareConcurrent(t *testing.T) {
+	memory := &trace.Memory{}
+	engine := testEngine(testServer(t), &trace.Tracer{Exporter: memory}, func(ctx *gin.Context) {
+		session := ctx.MustGet(CONTEXT).(*mgo.Session)
+		for i := 0; i < 5; i++ {
+			var doc bson.M
+			session.DB("test").C(ctx.Query("c")).Find(nil).One(&doc)
+		}
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			engine.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", fmt.Sprintf("/?c=c%d", i), nil))
+		}(i)
+	}
+	wg.Wait()
+
+	result := spans(memory)
+	for i := 0; i < 32; i++ {
+		root := fmt.Sprintf("c%d", i)
+		if len(result[root]) != 5 {
+			t.Fatalf("%s: %d spans", root, len(result[root]))
+		}
+		for _, span := range result[root] {
+			if span.Name != "mongodb find "+root {
+				t.Fatalf("%s under %s", span.Name, root)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package mongo
+
+import (
+	"reflect"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/globalsign/mgo"
+)
+
+type (
+	// session 持有的连接  since 为连接回到连接池的时间
+	sessionConn struct {
+		conn  *Conn
+		since time.Time
+	}
+)
+
+// mgo 没有导出 session 持有的 socket  通过反射读取
+// Strong Monotonic 模式下 socket 在 session 关闭前只被该 session 使用
+func sessionConns(session *mgo.Session) (conns []sessionConn) {
+	value := reflect.ValueOf(session).Elem()
+	m := field(value, "m")
+	if !m.IsValid() {
+		return
+	}
+	mutex, ok := m.Addr().Interface().(*sync.RWMutex)
+	if !ok {
+		return
+	}
+	mutex.RLock()
+	defer mutex.RUnlock()
+	for _, name := range []string{"masterSocket", "slaveSocket"} {
+		socket := field(value, name)
+		if !socket.IsValid() || socket.Kind() != reflect.Ptr || socket.IsNil() {
+			continue
+		}
+		socket = socket.Elem()
+		conn, ok := fieldInterface(socket, "conn").(*Conn)
+		if !ok {
+			continue
+		}
+		// Monotonic 写入后 slave 与 master 相同
+		if len(conns) != 0 && conns[0].conn == conn {
+			continue
+		}
+		since, _ := fieldInterface(socket, "lastTimeUsed").(time.Time)
+		conns = append(conns, sessionConn{conn: conn, since: since})
+	}
+	return
+}
+
+func field(value reflect.Value, name string) reflect.Value {
+	f := value.FieldByName(name)
+	if !f.IsValid() || !f.CanAddr() {
+		return reflect.Value{}
+	}
+	return reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
+}
+
+func fieldInterface(value reflect.Value, name string) interface{} {
+	if f := field(value, name); f.IsValid() {
+		return f.Interface()
+	}
+	return nil
+}
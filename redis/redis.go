@@ -1,9 +1,14 @@
 package redis
 
 import (
+	"strings"
+	"time"
+
 	"github.com/go-redis/redis"
 
 	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/stats"
+	"github.com/otamoe/gin-server/trace"
 )
 
 type (
@@ -16,7 +21,80 @@ func Middleware(getSession GetSession) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		session := getSession()
 		defer session.Close()
+		if span := trace.FromContext(ctx); span != nil {
+			Trace(session, span)
+		}
+		if collection := stats.FromContext(ctx); collection != nil {
+			Stats(session, collection)
+		}
 		ctx.Set(CONTEXT, session)
 		ctx.Next()
 	}
 }
+
+// 每个命令 和 pipeline 一个子 span
+func Trace(session *redis.Client, span *trace.Span) {
+	session.WrapProcess(func(process func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			child := span.Child("redis "+cmd.Name(), trace.KindClient)
+			child.SetAttribute("db.system", "redis")
+			child.SetAttribute("db.operation", cmd.Name())
+			err := process(cmd)
+			if err != redis.Nil {
+				child.SetError(err)
+			}
+			child.Finish()
+			return err
+		}
+	})
+	session.WrapProcessPipeline(func(process func(cmds []redis.Cmder) error) func(cmds []redis.Cmder) error {
+		return func(cmds []redis.Cmder) error {
+			names := make([]string, len(cmds))
+			for i, cmd := range cmds {
+				names[i] = cmd.Name()
+			}
+			child := span.Child("redis pipeline", trace.KindClient)
+			child.SetAttribute("db.system", "redis")
+			child.SetAttribute("db.operation", strings.Join(names, " "))
+			child.SetAttribute("db.redis.commands", len(cmds))
+			err := process(cmds)
+			if err != redis.Nil {
+				child.SetError(err)
+			}
+			child.Finish()
+			return err
+		}
+	})
+}
+
+// 命令 和 pipeline 的次数 耗时 慢命令  pipeline 算一次
+func Stats(session *redis.Client, collection *stats.Collection) {
+	session.WrapProcess(func(process func(cmd redis.Cmder) error) func(cmd redis.Cmder) error {
+		return func(cmd redis.Cmder) error {
+			start := time.Now()
+			err := process(cmd)
+			if err == redis.Nil {
+				collection.Add("redis", cmd.Name(), time.Since(start), nil)
+			} else {
+				collection.Add("redis", cmd.Name(), time.Since(start), err)
+			}
+			return err
+		}
+	})
+	session.WrapProcessPipeline(func(process func(cmds []redis.Cmder) error) func(cmds []redis.Cmder) error {
+		return func(cmds []redis.Cmder) error {
+			names := make([]string, len(cmds))
+			for i, cmd := range cmds {
+				names[i] = cmd.Name()
+			}
+			start := time.Now()
+			err := process(cmds)
+			if err == redis.Nil {
+				collection.Add("redis", "pipeline "+strings.Join(names, " "), time.Since(start), nil)
+			} else {
+				collection.Add("redis", "pipeline "+strings.Join(names, " "), time.Since(start), err)
+			}
+			return err
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+)
+
+var CONTEXT = "GIN.SERVER.REQUEST_ID"
+
+var Header = "X-Request-ID"
+
+var MaxLength = 128
+
+// 只允许 字母 数字 - _ . : 防止注入日志和响应头
+func Valid(id string) bool {
+	if id == "" || len(id) > MaxLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '-' || c == '_' || c == '.' || c == ':' {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// gin.Context 或 带有 CONTEXT 值的 context.Context
+func Get(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(CONTEXT).(string)
+	return id
+}
+
+// 外部请求 带上请求 id
+func Set(ctx context.Context, header http.Header) {
+	if id := Get(ctx); id != "" {
+		header.Set(Header, id)
+	}
+}
@@ -23,23 +23,25 @@ var ErrRequired = &errs.Error{
 	StatusCode: http.StatusUnauthorized,
 }
 
+func Validate(ctx *gin.Context) (params map[string]interface{}, err error) {
+	if val, ok := ctx.Get(CONTEXT); ok {
+		resource := ctx.MustGet(ginResource.CONTEXT).(*ginResource.Resource)
+		resource.Pre()
+		params, err = val.(Interface).ValidateScope(resource)
+	} else {
+		err = ErrRequired
+	}
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	ctx.Set(CONTEXT_PARAMS, params)
+	ctx.Set(CONTEXT_ERROR, err)
+	return
+}
+
 func Middleware(required bool) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		var err error
-		var params map[string]interface{}
-		if val, ok := ctx.Get(CONTEXT); ok {
-			resource := ctx.MustGet(ginResource.CONTEXT).(*ginResource.Resource)
-			resource.Pre()
-			params, err = val.(Interface).ValidateScope(resource)
-		} else {
-			err = ErrRequired
-		}
-		if params == nil {
-			params = map[string]interface{}{}
-		}
-		ctx.Set(CONTEXT_PARAMS, params)
-		ctx.Set(CONTEXT_ERROR, err)
-		if err != nil && required {
+		if _, err := Validate(ctx); err != nil && required {
 			ctx.Error(err)
 			ctx.Abort()
 		} else {
@@ -34,6 +34,7 @@ type (
 		Logger   *Logger    `json:"logger,omitempty"`
 		Redis    *Redis     `json:"redis,omitempty"`
 		Mongo    *Mongo     `json:"mongo,omitempty"`
+		Trace    *Trace     `json:"trace,omitempty"`
 		Handlers []*Handler `json:"handlers,omitempty"`
 
 		httpServer *http.Server
@@ -128,6 +129,9 @@ func (server *Server) Init() *Server {
 	if server.Mongo != nil {
 		server.Mongo.init(server, nil)
 	}
+	if server.Trace != nil {
+		server.Trace.init(server, nil)
+	}
 
 	return server
 }
@@ -239,5 +243,27 @@ func (server *Server) Start() {
 		logrus.Error("Server Shutdown:", err)
 	}
 
+	// 写入剩余的日志 和 span
+	if err := server.Logger.Close(ctx); err != nil {
+		logrus.Error("Logger Close:", err)
+	}
+	if server.Trace != nil {
+		if err := server.Trace.Close(ctx); err != nil {
+			logrus.Error("Trace Close:", err)
+		}
+	}
+	for _, handler := range server.Handlers {
+		if handler.Logger != nil && handler.Logger != server.Logger {
+			if err := handler.Logger.Close(ctx); err != nil {
+				logrus.Error("Logger Close:", err)
+			}
+		}
+		if handler.Trace != nil && handler.Trace != server.Trace {
+			if err := handler.Trace.Close(ctx); err != nil {
+				logrus.Error("Trace Close:", err)
+			}
+		}
+	}
+
 	logrus.Println("Server exiting")
 }
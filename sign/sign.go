@@ -0,0 +1,158 @@
+package sign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+)
+
+type (
+	Key struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+
+	// Keys 第一个用于签名  全部用于验证  轮换时新密钥放在最前
+	Signer struct {
+		Keys []Key
+	}
+
+	Options struct {
+		Expires time.Duration
+		IP      string
+		Method  string
+	}
+)
+
+var CONTEXT = "GIN.SERVER.SIGN"
+
+var (
+	PARAM_EXPIRES   = "expires"
+	PARAM_KEY       = "key"
+	PARAM_BIND      = "bind"
+	PARAM_SIGNATURE = "signature"
+)
+
+var ErrInvalid = &errs.Error{
+	Message:    "Invalid signature",
+	Type:       "signature",
+	StatusCode: http.StatusForbidden,
+}
+
+var ErrExpired = &errs.Error{
+	Message:    "Signature has expired",
+	Type:       "signature",
+	StatusCode: http.StatusForbidden,
+}
+
+func (signer *Signer) Sign(rawurl string, options Options) (signed string, err error) {
+	if len(signer.Keys) == 0 {
+		err = errors.New("Signer has no keys")
+		return
+	}
+	var u *url.URL
+	if u, err = url.Parse(rawurl); err != nil {
+		return
+	}
+	if options.Expires == 0 {
+		options.Expires = time.Hour
+	}
+
+	key := signer.Keys[0]
+	query := u.Query()
+	query.Del(PARAM_SIGNATURE)
+	query.Set(PARAM_EXPIRES, strconv.FormatInt(time.Now().Add(options.Expires).Unix(), 10))
+	query.Set(PARAM_KEY, key.ID)
+
+	var binds []string
+	if options.IP != "" {
+		binds = append(binds, "ip")
+	}
+	if options.Method != "" {
+		binds = append(binds, "method")
+	}
+	if len(binds) != 0 {
+		query.Set(PARAM_BIND, strings.Join(binds, ","))
+	} else {
+		query.Del(PARAM_BIND)
+	}
+
+	query.Set(PARAM_SIGNATURE, signature(key, u.EscapedPath(), query, options.IP, strings.ToUpper(options.Method)))
+	u.RawQuery = query.Encode()
+	signed = u.String()
+	return
+}
+
+func (signer *Signer) Verify(req *http.Request, ip string) (err error) {
+	query := req.URL.Query()
+
+	expires, e := strconv.ParseInt(query.Get(PARAM_EXPIRES), 10, 64)
+	if e != nil {
+		return ErrInvalid
+	}
+	if time.Now().Unix() > expires {
+		return ErrExpired
+	}
+
+	var key *Key
+	for i := range signer.Keys {
+		if signer.Keys[i].ID == query.Get(PARAM_KEY) {
+			key = &signer.Keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return ErrInvalid
+	}
+
+	var bindIP, bindMethod string
+	for _, val := range strings.Split(query.Get(PARAM_BIND), ",") {
+		switch val {
+		case "ip":
+			bindIP = ip
+		case "method":
+			bindMethod = req.Method
+		}
+	}
+
+	expected, _ := base64.RawURLEncoding.DecodeString(signature(*key, req.URL.EscapedPath(), query, bindIP, bindMethod))
+	actual, e := base64.RawURLEncoding.DecodeString(query.Get(PARAM_SIGNATURE))
+	if e != nil || !hmac.Equal(expected, actual) {
+		return ErrInvalid
+	}
+	return
+}
+
+// 签名内容  路径 排序后的参数 绑定的 ip 和方法
+func signature(key Key, path string, query url.Values, ip string, method string) string {
+	values := url.Values{}
+	for name, val := range query {
+		if name != PARAM_SIGNATURE {
+			values[name] = val
+		}
+	}
+	mac := hmac.New(sha256.New, []byte(key.Secret))
+	mac.Write([]byte(path + "?" + values.Encode() + "\n" + ip + "\n" + method))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func Middleware(signer *Signer) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if err := signer.Verify(ctx.Request, ctx.ClientIP()); err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+			return
+		}
+		ctx.Set(CONTEXT, true)
+		ctx.Next()
+	}
+}
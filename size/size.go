@@ -10,14 +10,17 @@ import (
 
 type Reader struct {
 	Remaining  int64
+	Count      int64
 	ctx        *gin.Context
 	rdr        io.ReadCloser
 	wasAborted bool
 	sawEOF     bool
 }
 
+var ErrTooLarge = errors.New(http.StatusText(http.StatusRequestEntityTooLarge))
+
 func (mbr *Reader) tooLarge() (n int, err error) {
-	err = errors.New(http.StatusText(http.StatusRequestEntityTooLarge))
+	err = ErrTooLarge
 	if !mbr.wasAborted {
 		mbr.wasAborted = true
 		ctx := mbr.ctx
@@ -56,6 +59,7 @@ func (mbr *Reader) Read(p []byte) (n int, err error) {
 		}
 		return 0, err
 	}
+	mbr.Count += int64(n)
 	mbr.Remaining -= int64(n)
 	if mbr.Remaining < 0 {
 		mbr.Remaining = 0
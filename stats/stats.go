@@ -0,0 +1,137 @@
+package stats
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// 单个请求的 mongo redis 等操作统计  由 logger 中间件创建
+	Collection struct {
+		// 超过时记录到 Slows  0 不记录
+		Slow time.Duration
+
+		mutex   sync.Mutex
+		stats   map[string]*Stats
+		sources []func()
+	}
+
+	Stats struct {
+		Count    int           `json:"count" bson:"count"`
+		Errors   int           `json:"errors,omitempty" bson:"errors,omitempty"`
+		Duration time.Duration `json:"duration" bson:"duration"`
+		Slows    []Operation   `json:"slows,omitempty" bson:"slows,omitempty"`
+	}
+
+	Operation struct {
+		Name     string        `json:"name" bson:"name"`
+		Duration time.Duration `json:"duration" bson:"duration"`
+		Error    string        `json:"error,omitempty" bson:"error,omitempty"`
+	}
+)
+
+var CONTEXT = "GIN.SERVER.STATS"
+
+// 每种最多记录的慢操作
+var MaxSlows = 16
+
+// gin.Context 或 带有 CONTEXT 值的 context.Context
+func FromContext(ctx context.Context) *Collection {
+	if ctx == nil {
+		return nil
+	}
+	collection, _ := ctx.Value(CONTEXT).(*Collection)
+	return collection
+}
+
+// nil collection 可以安全调用
+func (collection *Collection) Add(name string, operation string, duration time.Duration, err error) {
+	if collection == nil {
+		return
+	}
+	collection.mutex.Lock()
+	defer collection.mutex.Unlock()
+	if collection.stats == nil {
+		collection.stats = map[string]*Stats{}
+	}
+	stats, ok := collection.stats[name]
+	if !ok {
+		stats = &Stats{}
+		collection.stats[name] = stats
+	}
+	stats.Count++
+	stats.Duration += duration
+	if err != nil {
+		stats.Errors++
+	}
+	if collection.Slow > 0 && duration >= collection.Slow && len(stats.Slows) < MaxSlows {
+		slow := Operation{Name: operation, Duration: duration}
+		if err != nil {
+			slow.Error = err.Error()
+		}
+		stats.Slows = append(stats.Slows, slow)
+	}
+}
+
+// 读取前调用  用于事后汇总的操作 如 mongo 连接上的请求
+func (collection *Collection) AddSource(source func()) {
+	if collection == nil {
+		return
+	}
+	collection.mutex.Lock()
+	collection.sources = append(collection.sources, source)
+	collection.mutex.Unlock()
+}
+
+// 副本
+func (collection *Collection) Stats() map[string]Stats {
+	if collection == nil {
+		return nil
+	}
+	collection.mutex.Lock()
+	sources := collection.sources
+	collection.mutex.Unlock()
+	for _, source := range sources {
+		source()
+	}
+
+	collection.mutex.Lock()
+	defer collection.mutex.Unlock()
+	if len(collection.stats) == 0 {
+		return nil
+	}
+	result := make(map[string]Stats, len(collection.stats))
+	for name, stats := range collection.stats {
+		val := *stats
+		val.Slows = append([]Operation(nil), stats.Slows...)
+		result[name] = val
+	}
+	return result
+}
+
+// Server-Timing 响应头  redis;desc="3 ops";dur=1.25
+func (collection *Collection) ServerTiming() string {
+	stats := collection.Stats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]string, 0, len(names))
+	for _, name := range names {
+		values = append(values, Metric(name, strconv.Itoa(stats[name].Count)+" ops", stats[name].Duration))
+	}
+	return strings.Join(values, ", ")
+}
+
+func Metric(name string, desc string, duration time.Duration) string {
+	value := name
+	if desc != "" {
+		value += ";desc=" + strconv.Quote(desc)
+	}
+	return value + ";dur=" + strconv.FormatFloat(float64(duration)/float64(time.Millisecond), 'f', 2, 64)
+}
@@ -0,0 +1,86 @@
+package thumbnail
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/upload"
+)
+
+type (
+	Cache interface {
+		Get(ctx *gin.Context, key string) (data []byte, contentType string, ok bool)
+		Put(ctx *gin.Context, key string, data []byte, contentType string) error
+	}
+
+	DiskCache struct {
+		Root string
+	}
+
+	GridFSCache struct {
+		upload.GridFS
+	}
+)
+
+var extensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+func (cache *DiskCache) name(key string, contentType string) string {
+	return filepath.Join(cache.Root, key[:2], key+extensions[contentType])
+}
+
+func (cache *DiskCache) Get(ctx *gin.Context, key string) (data []byte, contentType string, ok bool) {
+	var err error
+	for contentType = range extensions {
+		if data, err = ioutil.ReadFile(cache.name(key, contentType)); err == nil {
+			ok = true
+			return
+		}
+	}
+	return nil, "", false
+}
+
+func (cache *DiskCache) Put(ctx *gin.Context, key string, data []byte, contentType string) (err error) {
+	name := cache.name(key, contentType)
+	if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return
+	}
+	// 先写临时文件 避免读到不完整的内容
+	tmp := name + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return
+	}
+	return os.Rename(tmp, name)
+}
+
+func (cache *GridFSCache) Get(ctx *gin.Context, key string) (data []byte, contentType string, ok bool) {
+	file, err := cache.GridFS.Get(ctx).Open("thumbnail/" + key)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if data, err = ioutil.ReadAll(file); err != nil {
+		return
+	}
+	return data, file.ContentType(), true
+}
+
+func (cache *GridFSCache) Put(ctx *gin.Context, key string, data []byte, contentType string) (err error) {
+	file, err := cache.GridFS.Get(ctx).Create("thumbnail/" + key)
+	if err != nil {
+		return
+	}
+	file.SetContentType(contentType)
+	if _, err = file.Write(data); err != nil {
+		file.Abort()
+	}
+	if e := file.Close(); err == nil {
+		err = e
+	}
+	return
+}
@@ -0,0 +1,354 @@
+package thumbnail
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otamoe/gin-server/errs"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+type (
+	Preset struct {
+		Width  int
+		Height int
+
+		// fit 等比缩放到范围内  fill 等比缩放后居中裁剪
+		Mode string
+
+		// jpeg png gif  空为原格式
+		Format  string
+		Quality int
+	}
+
+	// 放在 file.Middleware 或 file.GridFS 之前  按 ?preset= 处理原图
+	Config struct {
+		Presets map[string]Preset
+		Param   string
+		Control []string
+		Cache   Cache
+
+		// 原图最大字节数 和 最大像素数
+		MaxSize   int
+		MaxPixels int
+	}
+
+	captureWriter struct {
+		gin.ResponseWriter
+		status int
+		body   bytes.Buffer
+		limit  int
+		err    error
+
+		// 原图响应头写入时调用  返回 true 时不再读取原图内容
+		skip    func() bool
+		skipped bool
+	}
+)
+
+var ErrPreset = &errs.Error{
+	Message:    "Unknown image preset",
+	Type:       "thumbnail",
+	StatusCode: http.StatusBadRequest,
+}
+
+var ErrImage = &errs.Error{
+	Message:    "Unsupported image",
+	Type:       "thumbnail",
+	StatusCode: http.StatusUnsupportedMediaType,
+}
+
+var errTooLarge = errors.New("Image is too large")
+
+var errCached = errors.New("Image is cached")
+
+// 条件 范围 编码 请求头  获取原图时去掉
+var conditionalHeaders = []string{"Range", "If-Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "Accept-Encoding"}
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.Param == "" {
+		c.Param = "preset"
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = 1024 * 1024 * 20
+	}
+	if c.MaxPixels == 0 {
+		c.MaxPixels = 1024 * 1024 * 40
+	}
+	return func(ctx *gin.Context) {
+		presetName := ctx.Query(c.Param)
+		if presetName == "" || (ctx.Request.Method != http.MethodGet && ctx.Request.Method != http.MethodHead) {
+			ctx.Next()
+			return
+		}
+		preset, ok := c.Presets[presetName]
+		if !ok {
+			e := ErrPreset.Clone()
+			e.Value = presetName
+			ctx.Error(e)
+			ctx.Abort()
+			return
+		}
+
+		// 获取完整原图
+		req := ctx.Request
+		method := req.Method
+		req.Method = http.MethodGet
+		header := req.Header
+		req.Header = http.Header{}
+		for name, val := range header {
+			req.Header[name] = val
+		}
+		for _, name := range conditionalHeaders {
+			req.Header.Del(name)
+		}
+		writer := ctx.Writer
+		capture := &captureWriter{
+			ResponseWriter: writer,
+			limit:          c.MaxSize,
+		}
+
+		// 缓存按原图的响应头 (etag) 查找  在读取内容之前
+		// 命中时写入失败 原图最多读取一次复制缓冲区 (gridfs 为一个 chunk)  不会缓存到内存
+		var key string
+		var data []byte
+		var contentType string
+		var cached bool
+		if c.Cache != nil {
+			capture.skip = func() bool {
+				if !strings.HasPrefix(writer.Header().Get("Content-Type"), "image/") {
+					return false
+				}
+				key = c.key(req.URL.Path, writer.Header().Get("Etag"), presetName, preset)
+				data, contentType, cached = c.Cache.Get(ctx, key)
+				return cached
+			}
+		}
+		ctx.Writer = capture
+		ctx.Next()
+		ctx.Writer = writer
+		req.Method = method
+		req.Header = header
+
+		if len(ctx.Errors) != 0 {
+			return
+		}
+		if capture.err != nil {
+			ctx.Error(&errs.Error{
+				Message:    capture.err.Error(),
+				Type:       "thumbnail",
+				StatusCode: http.StatusRequestEntityTooLarge,
+			})
+			return
+		}
+		if !capture.Written() {
+			return
+		}
+		if capture.Status() != http.StatusOK || !strings.HasPrefix(writer.Header().Get("Content-Type"), "image/") {
+			writer.WriteHeader(capture.Status())
+			writer.Write(capture.body.Bytes())
+			return
+		}
+
+		responseHeader := writer.Header()
+		modTime, _ := time.Parse(http.TimeFormat, responseHeader.Get("Last-Modified"))
+
+		if key == "" {
+			key = c.key(req.URL.Path, responseHeader.Get("Etag"), presetName, preset)
+		}
+		if !cached {
+			var err error
+			if data, contentType, err = c.process(capture.body.Bytes(), preset); err != nil {
+				ctx.Error(err)
+				return
+			}
+			if c.Cache != nil {
+				c.Cache.Put(ctx, key, data, contentType)
+			}
+		}
+
+		for _, name := range []string{"Content-Length", "Content-Encoding", "Content-Range", "Content-Disposition", "Accept-Ranges"} {
+			responseHeader.Del(name)
+		}
+		responseHeader.Set("Content-Type", contentType)
+		responseHeader.Set("Etag", "\""+key[:32]+"\"")
+		if len(c.Control) != 0 {
+			responseHeader.Set("Cache-Control", strings.Join(c.Control, ","))
+		}
+		name := strings.TrimSuffix(path.Base(req.URL.Path), path.Ext(req.URL.Path))
+		if exts, _ := mime.ExtensionsByType(contentType); len(exts) != 0 {
+			name += exts[0]
+		}
+		http.ServeContent(writer, req, name, modTime, bytes.NewReader(data))
+	}
+}
+
+// 缓存键 原图地址 原图 etag 预设
+func (c Config) key(urlPath string, etag string, presetName string, preset Preset) string {
+	hash := sha256.New()
+	hash.Write([]byte(urlPath + "\n" + etag + "\n" + presetName + "\n" + strconv.Itoa(preset.Width) + "x" + strconv.Itoa(preset.Height) + preset.Mode + preset.Format))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func (c Config) process(data []byte, preset Preset) (result []byte, contentType string, err error) {
+	config, format, e := image.DecodeConfig(bytes.NewReader(data))
+	if e != nil {
+		err = ErrImage
+		return
+	}
+	if config.Width*config.Height > c.MaxPixels {
+		err = &errs.Error{
+			Message:    errTooLarge.Error(),
+			Type:       "thumbnail",
+			StatusCode: http.StatusRequestEntityTooLarge,
+		}
+		return
+	}
+	src, _, e := image.Decode(bytes.NewReader(data))
+	if e != nil {
+		err = ErrImage
+		return
+	}
+
+	dst := Resize(src, preset.Width, preset.Height, preset.Mode)
+
+	if preset.Format != "" {
+		format = preset.Format
+	}
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg", "jpg":
+		quality := preset.Quality
+		if quality == 0 {
+			quality = 85
+		}
+		contentType = "image/jpeg"
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: quality})
+	case "gif":
+		contentType = "image/gif"
+		err = gif.Encode(&buf, dst, nil)
+	default:
+		// webp 等只能解码的格式 输出为 png
+		contentType = "image/png"
+		err = png.Encode(&buf, dst)
+	}
+	result = buf.Bytes()
+	return
+}
+
+// 缩放  fill 居中裁剪  宽或高为 0 时按比例计算  不放大
+func Resize(src image.Image, width int, height int, mode string) image.Image {
+	bounds := src.Bounds()
+	srcWidth := bounds.Dx()
+	srcHeight := bounds.Dy()
+	if srcWidth == 0 || srcHeight == 0 {
+		return src
+	}
+	if width == 0 && height == 0 {
+		return src
+	}
+	if width == 0 {
+		width = srcWidth * height / srcHeight
+	}
+	if height == 0 {
+		height = srcHeight * width / srcWidth
+	}
+
+	srcRect := bounds
+	dstWidth, dstHeight := width, height
+	if mode == "fill" {
+		// 按目标比例裁剪原图
+		if srcWidth*height > srcHeight*width {
+			cropWidth := srcHeight * width / height
+			x := bounds.Min.X + (srcWidth-cropWidth)/2
+			srcRect = image.Rect(x, bounds.Min.Y, x+cropWidth, bounds.Max.Y)
+		} else {
+			cropHeight := srcWidth * height / width
+			y := bounds.Min.Y + (srcHeight-cropHeight)/2
+			srcRect = image.Rect(bounds.Min.X, y, bounds.Max.X, y+cropHeight)
+		}
+		if dstWidth > srcRect.Dx() {
+			dstWidth, dstHeight = srcRect.Dx(), srcRect.Dy()
+		}
+	} else {
+		if srcWidth*height > srcHeight*width {
+			dstHeight = srcHeight * width / srcWidth
+		} else {
+			dstWidth = srcWidth * height / srcHeight
+		}
+		if dstWidth > srcWidth {
+			dstWidth, dstHeight = srcWidth, srcHeight
+		}
+	}
+	if dstWidth < 1 {
+		dstWidth = 1
+	}
+	if dstHeight < 1 {
+		dstHeight = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, srcRect, draw.Src, nil)
+	return dst
+}
+
+func (w *captureWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+		if code == http.StatusOK && w.skip != nil {
+			w.skipped = w.skip()
+		}
+	}
+}
+
+func (w *captureWriter) WriteHeaderNow() {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (w *captureWriter) Write(data []byte) (int, error) {
+	w.WriteHeaderNow()
+	if w.skipped {
+		return 0, errCached
+	}
+	if w.body.Len()+len(data) > w.limit {
+		w.err = errTooLarge
+		return 0, errTooLarge
+	}
+	return w.body.Write(data)
+}
+
+func (w *captureWriter) WriteString(data string) (int, error) {
+	return w.Write([]byte(data))
+}
+
+func (w *captureWriter) Status() int {
+	if w.status == 0 {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *captureWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *captureWriter) Written() bool {
+	return w.status != 0
+}
+
+func (w *captureWriter) Flush() {
+}
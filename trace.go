@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/otamoe/gin-server/trace"
+)
+
+type (
+	// OTLP/HTTP collector
+	Trace struct {
+		Endpoint string            `json:"endpoint,omitempty"`
+		Headers  map[string]string `json:"headers,omitempty"`
+		Service  string            `json:"service,omitempty"`
+		Timeout  time.Duration     `json:"timeout,omitempty"`
+
+		tracer *trace.Tracer
+	}
+)
+
+func (config *Trace) init(server *Server, handler *Handler) {
+	if config.tracer != nil {
+		return
+	}
+	if config.Service == "" {
+		if handler != nil && handler.Name != "" {
+			config.Service = handler.Name
+		} else if server != nil {
+			config.Service = server.Name
+		}
+	}
+	config.tracer = &trace.Tracer{
+		Service: config.Service,
+		Exporter: &trace.OTLP{
+			Endpoint: config.Endpoint,
+			Headers:  config.Headers,
+			Service:  config.Service,
+			Timeout:  config.Timeout,
+		},
+	}
+}
+
+func (config *Trace) Get() *trace.Tracer {
+	return config.tracer
+}
+
+func (config *Trace) Close(ctx context.Context) error {
+	if config.tracer == nil {
+		return nil
+	}
+	return config.tracer.Shutdown(ctx)
+}
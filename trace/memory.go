@@ -0,0 +1,37 @@
+package trace
+
+import (
+	"context"
+	"sync"
+)
+
+type (
+	// 保存在内存  测试使用
+	Memory struct {
+		mutex sync.Mutex
+		spans []*Span
+	}
+)
+
+func (memory *Memory) Export(spans []*Span) error {
+	memory.mutex.Lock()
+	memory.spans = append(memory.spans, spans...)
+	memory.mutex.Unlock()
+	return nil
+}
+
+func (memory *Memory) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+func (memory *Memory) Spans() []*Span {
+	memory.mutex.Lock()
+	defer memory.mutex.Unlock()
+	return append([]*Span{}, memory.spans...)
+}
+
+func (memory *Memory) Reset() {
+	memory.mutex.Lock()
+	memory.spans = nil
+	memory.mutex.Unlock()
+}
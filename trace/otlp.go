@@ -0,0 +1,269 @@
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// OTLP/HTTP JSON  异步批量发送到 collector
+	OTLP struct {
+		Endpoint      string
+		Headers       map[string]string
+		Service       string
+		Timeout       time.Duration
+		QueueSize     int
+		BatchSize     int
+		FlushInterval time.Duration
+		Client        *http.Client
+		Logrus        *logrus.Logger
+
+		queue   chan *Span
+		done    chan struct{}
+		dropped uint64
+		once    sync.Once
+		mutex   sync.RWMutex
+		closed  bool
+	}
+
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+	}
+
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		TraceState        string          `json:"traceState,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+)
+
+var DefaultEndpoint = "http://localhost:4318/v1/traces"
+
+func (otlp *OTLP) start() {
+	otlp.once.Do(func() {
+		if otlp.Endpoint == "" {
+			otlp.Endpoint = DefaultEndpoint
+		}
+		if otlp.Timeout == 0 {
+			otlp.Timeout = time.Second * 10
+		}
+		if otlp.QueueSize == 0 {
+			otlp.QueueSize = 4096
+		}
+		if otlp.BatchSize == 0 {
+			otlp.BatchSize = 512
+		}
+		if otlp.FlushInterval == 0 {
+			otlp.FlushInterval = time.Second * 5
+		}
+		if otlp.Client == nil {
+			otlp.Client = &http.Client{Timeout: otlp.Timeout}
+		}
+		if otlp.Logrus == nil {
+			otlp.Logrus = logrus.StandardLogger()
+		}
+		otlp.queue = make(chan *Span, otlp.QueueSize)
+		otlp.done = make(chan struct{})
+		go otlp.run()
+	})
+}
+
+// 不阻塞  队列满时丢弃
+func (otlp *OTLP) Export(spans []*Span) error {
+	otlp.start()
+	otlp.mutex.RLock()
+	defer otlp.mutex.RUnlock()
+	for _, span := range spans {
+		if otlp.closed {
+			atomic.AddUint64(&otlp.dropped, 1)
+			continue
+		}
+		select {
+		case otlp.queue <- span:
+		default:
+			atomic.AddUint64(&otlp.dropped, 1)
+		}
+	}
+	return nil
+}
+
+func (otlp *OTLP) Dropped() uint64 {
+	return atomic.LoadUint64(&otlp.dropped)
+}
+
+func (otlp *OTLP) Shutdown(ctx context.Context) error {
+	otlp.start()
+	otlp.mutex.Lock()
+	if !otlp.closed {
+		otlp.closed = true
+		close(otlp.queue)
+	}
+	otlp.mutex.Unlock()
+	select {
+	case <-otlp.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (otlp *OTLP) run() {
+	defer close(otlp.done)
+	ticker := time.NewTicker(otlp.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, otlp.BatchSize)
+	for {
+		select {
+		case span, ok := <-otlp.queue:
+			if !ok {
+				otlp.flush(batch)
+				return
+			}
+			batch = append(batch, span)
+			if len(batch) >= otlp.BatchSize {
+				otlp.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			otlp.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (otlp *OTLP) flush(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	if err := otlp.send(batch); err != nil {
+		otlp.Logrus.WithError(err).WithField("count", len(batch)).Error("[TRACE] otlp export")
+	}
+}
+
+func (otlp *OTLP) send(batch []*Span) (err error) {
+	body, err := json.Marshal(otlp.encode(batch))
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, otlp.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, val := range otlp.Headers {
+		req.Header.Set(name, val)
+	}
+	res, err := otlp.Client.Do(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		err = fmt.Errorf("otlp: %s", res.Status)
+	}
+	return
+}
+
+func (otlp *OTLP) encode(batch []*Span) map[string]interface{} {
+	service := otlp.Service
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, span := range batch {
+		if service == "" && span.tracer != nil {
+			service = span.tracer.Service
+		}
+		span.mutex.Lock()
+		val := otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			TraceState:        span.Context.State,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        attributes(span.Attributes),
+			Status:            otlpStatus{Code: span.Status, Message: span.StatusMessage},
+		}
+		span.mutex.Unlock()
+		if span.Parent.IsValid() {
+			val.ParentSpanID = span.Parent.String()
+		}
+		spans = append(spans, val)
+	}
+	if service == "" {
+		service = "unknown_service"
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": attributes(map[string]interface{}{"service.name": service}),
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "github.com/otamoe/gin-server/trace"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+}
+
+func attributes(attrs map[string]interface{}) []otlpAttribute {
+	result := make([]otlpAttribute, 0, len(attrs))
+	for key, val := range attrs {
+		var value otlpValue
+		switch val := val.(type) {
+		case string:
+			value.StringValue = &val
+		case bool:
+			value.BoolValue = &val
+		case int:
+			s := strconv.FormatInt(int64(val), 10)
+			value.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(val, 10)
+			value.IntValue = &s
+		case float64:
+			value.DoubleValue = &val
+		default:
+			s := fmt.Sprint(val)
+			value.StringValue = &s
+		}
+		result = append(result, otlpAttribute{Key: key, Value: value})
+	}
+	return result
+}
@@ -0,0 +1,246 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+
+	// W3C trace context
+	SpanContext struct {
+		TraceID TraceID
+		SpanID  SpanID
+		Flags   byte
+		State   string
+	}
+
+	Span struct {
+		Name          string
+		Kind          int
+		Context       SpanContext
+		Parent        SpanID
+		Start         time.Time
+		End           time.Time
+		Attributes    map[string]interface{}
+		Status        int
+		StatusMessage string
+
+		tracer *Tracer
+		mutex  sync.Mutex
+		ended  bool
+	}
+
+	Exporter interface {
+		Export(spans []*Span) error
+		Shutdown(ctx context.Context) error
+	}
+
+	Tracer struct {
+		Service  string
+		Exporter Exporter
+	}
+)
+
+const (
+	KindInternal = 1
+	KindServer   = 2
+	KindClient   = 3
+
+	StatusUnset = 0
+	StatusOK    = 1
+	StatusError = 2
+
+	FlagSampled = 0x01
+)
+
+var CONTEXT = "GIN.SERVER.TRACE"
+
+var (
+	HeaderParent = "traceparent"
+	HeaderState  = "tracestate"
+)
+
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+func (sc SpanContext) Sampled() bool {
+	return sc.Flags&FlagSampled != 0
+}
+
+// traceparent 格式  00-<trace-id>-<span-id>-<flags>
+func (sc SpanContext) String() string {
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + hex.EncodeToString([]byte{sc.Flags})
+}
+
+func Parse(traceparent string) (sc SpanContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return
+	}
+	// 版本 ff 无效  00 不允许多余字段
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return
+	}
+	for _, part := range parts[:4] {
+		if strings.ToLower(part) != part {
+			return
+		}
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return
+	}
+	if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return
+	}
+	sc.Flags = flags[0]
+	ok = sc.IsValid()
+	return
+}
+
+// 从请求头读取
+func Extract(header http.Header) (sc SpanContext, ok bool) {
+	if sc, ok = Parse(header.Get(HeaderParent)); ok {
+		sc.State = header.Get(HeaderState)
+	}
+	return
+}
+
+// 写入外部请求头  ctx 为 gin.Context 或 带有 CONTEXT 值的 context.Context
+func Inject(ctx context.Context, header http.Header) {
+	span := FromContext(ctx)
+	if span == nil {
+		return
+	}
+	header.Set(HeaderParent, span.Context.String())
+	if span.Context.State != "" {
+		header.Set(HeaderState, span.Context.State)
+	} else {
+		header.Del(HeaderState)
+	}
+}
+
+func FromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(CONTEXT).(*Span)
+	return span
+}
+
+func newID(b []byte) {
+	for {
+		rand.Read(b)
+		for _, val := range b {
+			if val != 0 {
+				return
+			}
+		}
+	}
+}
+
+// parent 无效时创建新的 trace  默认采样
+func (tracer *Tracer) Start(parent SpanContext, name string, kind int) *Span {
+	span := &Span{
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: map[string]interface{}{},
+		tracer:     tracer,
+	}
+	if parent.IsValid() {
+		span.Context = parent
+		span.Parent = parent.SpanID
+	} else {
+		newID(span.Context.TraceID[:])
+		span.Context.Flags = FlagSampled
+	}
+	newID(span.Context.SpanID[:])
+	return span
+}
+
+func (tracer *Tracer) Shutdown(ctx context.Context) error {
+	if tracer.Exporter == nil {
+		return nil
+	}
+	return tracer.Exporter.Shutdown(ctx)
+}
+
+// nil span 的方法都可以安全调用
+func (span *Span) Child(name string, kind int) *Span {
+	if span == nil {
+		return nil
+	}
+	return span.tracer.Start(span.Context, name, kind)
+}
+
+func (span *Span) SetAttribute(key string, val interface{}) {
+	if span == nil {
+		return
+	}
+	span.mutex.Lock()
+	span.Attributes[key] = val
+	span.mutex.Unlock()
+}
+
+func (span *Span) SetError(err error) {
+	if span == nil || err == nil {
+		return
+	}
+	span.mutex.Lock()
+	span.Status = StatusError
+	span.StatusMessage = err.Error()
+	span.mutex.Unlock()
+}
+
+// 结束并导出  只导出采样的
+func (span *Span) Finish() {
+	span.FinishAt(time.Now())
+}
+
+// 事后记录的操作  如 mongo 连接上的请求
+func (span *Span) FinishAt(end time.Time) {
+	if span == nil {
+		return
+	}
+	span.mutex.Lock()
+	if span.ended {
+		span.mutex.Unlock()
+		return
+	}
+	span.ended = true
+	span.End = end
+	span.mutex.Unlock()
+
+	if span.tracer.Exporter != nil && span.Context.Sampled() {
+		span.tracer.Exporter.Export([]*Span{span})
+	}
+}
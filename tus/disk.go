@@ -0,0 +1,100 @@
+package tus
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	Disk struct {
+		Root string
+	}
+)
+
+// 未完成的上传 写入 Name + DiskPartial  完成后重命名
+var DiskPartial = ".part"
+
+// 完成后的文件
+func (disk *Disk) Name(upload *Upload) string {
+	return filepath.Join(disk.Root, upload.ID)
+}
+
+func (disk *Disk) partial(upload *Upload) string {
+	return disk.Name(upload) + DiskPartial
+}
+
+func (disk *Disk) Create(ctx *gin.Context, upload *Upload) (err error) {
+	if err = os.MkdirAll(disk.Root, 0755); err != nil {
+		return
+	}
+	var file *os.File
+	if file, err = os.OpenFile(disk.partial(upload), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); err != nil {
+		return
+	}
+	return file.Close()
+}
+
+func (disk *Disk) Append(ctx *gin.Context, upload *Upload, reader io.Reader) (n int64, err error) {
+	var file *os.File
+	if file, err = os.OpenFile(disk.partial(upload), os.O_WRONLY, 0644); err != nil {
+		return
+	}
+	defer file.Close()
+
+	// 截断上次中断后多写的部分
+	if err = file.Truncate(upload.Offset); err != nil {
+		return
+	}
+	if _, err = file.Seek(upload.Offset, io.SeekStart); err != nil {
+		return
+	}
+	n, err = io.Copy(file, reader)
+	return
+}
+
+func (disk *Disk) Finish(ctx *gin.Context, upload *Upload) (err error) {
+	if err = os.Rename(disk.partial(upload), disk.Name(upload)); os.IsNotExist(err) {
+		// Complete 失败后重试  已经重命名
+		if _, e := os.Stat(disk.Name(upload)); e == nil {
+			err = nil
+		}
+	}
+	return
+}
+
+func (disk *Disk) Remove(ctx *gin.Context, upload *Upload) (err error) {
+	for _, name := range []string{disk.partial(upload), disk.Name(upload)} {
+		if err = os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return
+		}
+	}
+	err = nil
+	return
+}
+
+// 删除超过 expiration 未修改的未完成上传  redis 中的状态过期后数据不会自动删除
+func (disk *Disk) Cleanup(expiration time.Duration) (err error) {
+	var files []os.FileInfo
+	var dir *os.File
+	if dir, err = os.Open(disk.Root); err != nil {
+		return
+	}
+	files, err = dir.Readdir(-1)
+	dir.Close()
+	if err != nil {
+		return
+	}
+	before := time.Now().Add(-expiration)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), DiskPartial) || file.ModTime().After(before) {
+			continue
+		}
+		os.Remove(filepath.Join(disk.Root, file.Name()))
+	}
+	return
+}
@@ -0,0 +1,169 @@
+package tus
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/upload"
+)
+
+type (
+	// 直接写入 GridFS 的 chunks  完成后创建 files 文档
+	// 未完成的上传记录在 <prefix>.uploads
+	GridFS struct {
+		upload.GridFS
+	}
+
+	gridfsChunk struct {
+		ID      bson.ObjectId `bson:"_id"`
+		FilesID bson.ObjectId `bson:"files_id"`
+		N       int           `bson:"n"`
+		Data    []byte        `bson:"data"`
+	}
+)
+
+var GridFSChunkSize = 255 * 1024
+
+func (gridfs *GridFS) uploads(fs *mgo.GridFS) *mgo.Collection {
+	return fs.Files.Database.C(fs.Files.Name[:len(fs.Files.Name)-len(".files")] + ".uploads")
+}
+
+func (gridfs *GridFS) Create(ctx *gin.Context, upload *Upload) error {
+	fs := gridfs.Get(ctx)
+	return gridfs.uploads(fs).Insert(bson.M{
+		"_id":     bson.ObjectIdHex(upload.ID),
+		"expires": upload.Expires,
+	})
+}
+
+func (gridfs *GridFS) Append(ctx *gin.Context, upload *Upload, reader io.Reader) (written int64, err error) {
+	fs := gridfs.Get(ctx)
+	id := bson.ObjectIdHex(upload.ID)
+	n := int(upload.Offset / int64(GridFSChunkSize))
+	rem := int(upload.Offset % int64(GridFSChunkSize))
+
+	if err = gridfs.uploads(fs).UpdateId(id, bson.M{"$set": bson.M{"expires": upload.Expires}}); err != nil {
+		return
+	}
+
+	// 删除上次中断后多写的块
+	if _, err = fs.Chunks.RemoveAll(bson.M{"files_id": id, "n": bson.M{"$gt": n}}); err != nil {
+		return
+	}
+
+	// 补齐最后一个不完整的块
+	buf := make([]byte, GridFSChunkSize)
+	if rem != 0 {
+		chunk := gridfsChunk{}
+		if err = fs.Chunks.Find(bson.M{"files_id": id, "n": n}).One(&chunk); err != nil {
+			return
+		}
+		copy(buf, chunk.Data[:rem])
+	}
+
+	for {
+		var size int
+		size, err = io.ReadFull(reader, buf[rem:])
+		if size != 0 {
+			if _, e := fs.Chunks.Upsert(bson.M{"files_id": id, "n": n}, bson.M{
+				"$set": bson.M{"data": buf[:rem+size]},
+				"$setOnInsert": bson.M{
+					"_id": bson.NewObjectId(),
+				},
+			}); e != nil {
+				err = e
+				return
+			}
+			written += int64(size)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = nil
+			return
+		}
+		if err != nil {
+			return
+		}
+		n++
+		rem = 0
+	}
+}
+
+func (gridfs *GridFS) Finish(ctx *gin.Context, upload *Upload) (err error) {
+	fs := gridfs.Get(ctx)
+	id := bson.ObjectIdHex(upload.ID)
+
+	hash := md5.New()
+	chunk := gridfsChunk{}
+	iter := fs.Chunks.Find(bson.M{"files_id": id}).Sort("n").Iter()
+	for iter.Next(&chunk) {
+		hash.Write(chunk.Data)
+	}
+	if err = iter.Close(); err != nil {
+		return
+	}
+
+	metadata := bson.M{}
+	if upload.Owner != "" {
+		metadata["owner"] = upload.Owner
+	}
+	if err = fs.Files.Insert(bson.M{
+		"_id":         id,
+		"chunkSize":   GridFSChunkSize,
+		"uploadDate":  time.Now(),
+		"length":      upload.Length,
+		"md5":         hex.EncodeToString(hash.Sum(nil)),
+		"filename":    upload.Metadata["filename"],
+		"contentType": upload.Metadata["filetype"],
+		"metadata":    metadata,
+	}); mgo.IsDup(err) {
+		// Complete 失败后重试  文件已经创建
+		err = nil
+	} else if err != nil {
+		return
+	}
+	if err = gridfs.uploads(fs).RemoveId(id); err == mgo.ErrNotFound {
+		err = nil
+	}
+	return
+}
+
+func (gridfs *GridFS) Remove(ctx *gin.Context, upload *Upload) (err error) {
+	fs := gridfs.Get(ctx)
+	id := bson.ObjectIdHex(upload.ID)
+	if _, err = fs.Chunks.RemoveAll(bson.M{"files_id": id}); err != nil {
+		return
+	}
+	if _, err = gridfs.uploads(fs).RemoveAll(bson.M{"_id": id}); err != nil {
+		return
+	}
+	_, err = fs.Files.RemoveAll(bson.M{"_id": id})
+	return
+}
+
+// 删除过期未完成的上传
+func (gridfs *GridFS) Cleanup(session *mgo.Session) (err error) {
+	prefix := gridfs.Prefix
+	if prefix == "" {
+		prefix = "fs"
+	}
+	fs := session.DB(gridfs.Database).GridFS(prefix)
+	uploads := gridfs.uploads(fs)
+
+	var result struct {
+		ID bson.ObjectId `bson:"_id"`
+	}
+	iter := uploads.Find(bson.M{"expires": bson.M{"$lt": time.Now()}}).Select(bson.M{"_id": 1}).Iter()
+	for iter.Next(&result) {
+		if _, err = fs.Chunks.RemoveAll(bson.M{"files_id": result.ID}); err != nil {
+			iter.Close()
+			return
+		}
+		uploads.RemoveId(result.ID)
+	}
+	return iter.Close()
+}
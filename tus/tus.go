@@ -0,0 +1,468 @@
+package tus
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo/bson"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/errs"
+	redisMiddleware "github.com/otamoe/gin-server/redis"
+	ginResource "github.com/otamoe/gin-server/resource"
+	"github.com/otamoe/gin-server/scope"
+	"github.com/otamoe/gin-server/size"
+)
+
+type (
+	// tus 1.0.0 断点续传  扩展 creation termination expiration
+	Config struct {
+		Store Store
+
+		// 文件最大长度
+		MaxSize int64
+
+		// 单次 PATCH 最大长度
+		MaxChunk int64
+
+		// 未完成的上传过期时间
+		Expiration time.Duration
+
+		// 通过 scope 验证权限
+		Scope bool
+
+		// 上传完成
+		Complete func(ctx *gin.Context, upload *Upload) error
+	}
+
+	Upload struct {
+		ID       string            `json:"id"`
+		Length   int64             `json:"length"`
+		Offset   int64             `json:"offset"`
+		Metadata map[string]string `json:"metadata,omitempty"`
+		Owner    bson.ObjectId     `json:"owner,omitempty"`
+		Expires  time.Time         `json:"expires"`
+
+		// Store.Finish 和 Complete 已执行
+		Completed bool `json:"completed,omitempty"`
+	}
+
+	Store interface {
+		Create(ctx *gin.Context, upload *Upload) error
+		Append(ctx *gin.Context, upload *Upload, reader io.Reader) (int64, error)
+		Finish(ctx *gin.Context, upload *Upload) error
+		Remove(ctx *gin.Context, upload *Upload) error
+	}
+)
+
+var CONTEXT = "GIN.SERVER.TUS"
+
+var PREFIX = "tus"
+
+var (
+	VERSION    = "1.0.0"
+	EXTENSIONS = "creation,termination,expiration"
+)
+
+var ErrNotFound = &errs.Error{
+	Message:    http.StatusText(http.StatusNotFound),
+	Type:       "not_found",
+	StatusCode: http.StatusNotFound,
+}
+
+var ErrOffset = &errs.Error{
+	Message:    "Upload offset does not match",
+	Type:       "tus",
+	StatusCode: http.StatusConflict,
+}
+
+var ErrLocked = &errs.Error{
+	Message:    "Upload is in progress",
+	Type:       "tus",
+	StatusCode: http.StatusConflict,
+}
+
+func (c *Config) init() {
+	if c.MaxChunk == 0 {
+		c.MaxChunk = 1024 * 1024 * 64
+	}
+	if c.Expiration == 0 {
+		c.Expiration = time.Hour * 24
+	}
+}
+
+// 注册路由  group 需要 redis 中间件
+func (c Config) Register(group gin.IRoutes) {
+	c.init()
+	group.Use(c.version)
+	group.OPTIONS("", c.options)
+	group.POST("", c.create)
+	group.OPTIONS("/:id", c.options)
+	group.HEAD("/:id", c.head)
+	group.PATCH("/:id", c.patch)
+	group.DELETE("/:id", c.remove)
+}
+
+func (c Config) version(ctx *gin.Context) {
+	ctx.Header("Tus-Resumable", VERSION)
+	if ctx.Request.Method == http.MethodOptions {
+		ctx.Next()
+		return
+	}
+	if val := ctx.GetHeader("Tus-Resumable"); val != VERSION {
+		ctx.Header("Tus-Version", VERSION)
+		ctx.Error(&errs.Error{
+			Message:    "Unsupported tus version",
+			Type:       "tus",
+			Value:      val,
+			StatusCode: http.StatusPreconditionFailed,
+		})
+		ctx.Abort()
+		return
+	}
+	ctx.Next()
+}
+
+func (c Config) options(ctx *gin.Context) {
+	ctx.Header("Tus-Version", VERSION)
+	ctx.Header("Tus-Extension", EXTENSIONS)
+	if c.MaxSize != 0 {
+		ctx.Header("Tus-Max-Size", strconv.FormatInt(c.MaxSize, 10))
+	}
+	ctx.AbortWithStatus(http.StatusNoContent)
+}
+
+func (c Config) create(ctx *gin.Context) {
+	var err error
+	defer func() {
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+		}
+	}()
+
+	length, e := strconv.ParseInt(ctx.GetHeader("Upload-Length"), 10, 64)
+	if e != nil || length < 0 {
+		err = &errs.Error{
+			Message:    "Invalid Upload-Length",
+			Type:       "tus",
+			StatusCode: http.StatusBadRequest,
+		}
+		return
+	}
+	if c.MaxSize != 0 && length > c.MaxSize {
+		err = &errs.Error{
+			Message:    http.StatusText(http.StatusRequestEntityTooLarge),
+			Type:       "tus",
+			StatusCode: http.StatusRequestEntityTooLarge,
+			Params: map[string]interface{}{
+				"max": c.MaxSize,
+			},
+		}
+		return
+	}
+
+	upload := &Upload{
+		ID:       bson.NewObjectId().Hex(),
+		Length:   length,
+		Metadata: parseMetadata(ctx.GetHeader("Upload-Metadata")),
+		Expires:  time.Now().Add(c.Expiration),
+	}
+
+	resource := ctx.MustGet(ginResource.CONTEXT).(*ginResource.Resource)
+	if err = c.authorize(ctx, resource, "create", upload); err != nil {
+		return
+	}
+	upload.Owner = resource.Owner
+
+	if err = c.Store.Create(ctx, upload); err != nil {
+		return
+	}
+	if err = c.save(ctx, upload); err != nil {
+		c.Store.Remove(ctx, upload)
+		return
+	}
+
+	ctx.Header("Location", strings.TrimSuffix(ctx.Request.URL.Path, "/")+"/"+upload.ID)
+	ctx.Header("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	ctx.Set(CONTEXT, upload)
+
+	if length == 0 {
+		if err = c.finish(ctx, upload); err != nil {
+			return
+		}
+	}
+	ctx.AbortWithStatus(http.StatusCreated)
+}
+
+func (c Config) head(ctx *gin.Context) {
+	upload, err := c.load(ctx, "read")
+	if err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	ctx.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	ctx.Header("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	if len(upload.Metadata) != 0 {
+		ctx.Header("Upload-Metadata", formatMetadata(upload.Metadata))
+	}
+	ctx.AbortWithStatus(http.StatusOK)
+}
+
+func (c Config) patch(ctx *gin.Context) {
+	var err error
+	defer func() {
+		if err != nil {
+			ctx.Error(err)
+			ctx.Abort()
+		}
+	}()
+
+	if ctx.ContentType() != "application/offset+octet-stream" {
+		err = &errs.Error{
+			Message:    http.StatusText(http.StatusUnsupportedMediaType),
+			Type:       "tus",
+			StatusCode: http.StatusUnsupportedMediaType,
+		}
+		return
+	}
+
+	var upload *Upload
+	if upload, err = c.load(ctx, "write"); err != nil {
+		return
+	}
+
+	offset, e := strconv.ParseInt(ctx.GetHeader("Upload-Offset"), 10, 64)
+	if e != nil || offset < 0 {
+		err = &errs.Error{
+			Message:    "Invalid Upload-Offset",
+			Type:       "tus",
+			StatusCode: http.StatusBadRequest,
+		}
+		return
+	}
+
+	// 同一上传同时只能有一个 PATCH
+	redisClient := ctx.MustGet(redisMiddleware.CONTEXT).(*redis.Client)
+	lock := key(upload.ID) + ".lock"
+	var locked bool
+	if locked, err = redisClient.SetNX(lock, 1, time.Minute*10).Result(); err != nil {
+		return
+	}
+	if !locked {
+		err = ErrLocked
+		return
+	}
+	defer redisClient.Del(lock)
+
+	// 加锁后重新读取
+	if upload, err = c.get(ctx, upload.ID); err != nil {
+		return
+	}
+
+	// 重试最后一次 PATCH  客户端没有收到回复 偏移仍是写入前的位置
+	retry := offset != upload.Offset && upload.Offset == upload.Length && ctx.Request.ContentLength >= 0 && offset+ctx.Request.ContentLength == upload.Length
+	if offset != upload.Offset && !retry {
+		e := ErrOffset.Clone()
+		e.Params = map[string]interface{}{
+			"offset": upload.Offset,
+		}
+		err = e
+		return
+	}
+
+	// 已完成  不再执行 finish
+	if upload.Completed {
+		ctx.Set(CONTEXT, upload)
+		ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		ctx.Header("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+		ctx.AbortWithStatus(http.StatusNoContent)
+		return
+	}
+
+	// 替换 size.Middleware 的限制
+	remaining := upload.Length - upload.Offset
+	if remaining > c.MaxChunk {
+		remaining = c.MaxChunk
+	}
+	if reader, ok := ctx.Request.Body.(*size.Reader); ok {
+		reader.Remaining = remaining
+	}
+
+	upload.Expires = time.Now().Add(c.Expiration)
+
+	// 已全部写入 上次 finish 失败  直接重试 finish
+	if remaining != 0 {
+		n, e := c.Store.Append(ctx, upload, io.LimitReader(ctx.Request.Body, remaining))
+		upload.Offset += n
+
+		// 已写入的部分 即使出错也保存
+		if n != 0 {
+			if err = c.save(ctx, upload); err != nil {
+				return
+			}
+		}
+		if e != nil && e != io.ErrUnexpectedEOF {
+			err = e
+			return
+		}
+	}
+
+	ctx.Set(CONTEXT, upload)
+	ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	ctx.Header("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+
+	if upload.Offset == upload.Length {
+		if err = c.finish(ctx, upload); err != nil {
+			return
+		}
+		upload.Completed = true
+		if err = c.save(ctx, upload); err != nil {
+			return
+		}
+	}
+	ctx.AbortWithStatus(http.StatusNoContent)
+}
+
+func (c Config) remove(ctx *gin.Context) {
+	upload, err := c.load(ctx, "delete")
+	if err == nil {
+		if err = c.Store.Remove(ctx, upload); err == nil {
+			err = ctx.MustGet(redisMiddleware.CONTEXT).(*redis.Client).Del(key(upload.ID)).Err()
+		}
+	}
+	if err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+	ctx.AbortWithStatus(http.StatusNoContent)
+}
+
+func (c Config) finish(ctx *gin.Context, upload *Upload) (err error) {
+	if err = c.Store.Finish(ctx, upload); err != nil {
+		return
+	}
+	if c.Complete != nil {
+		if err = c.Complete(ctx, upload); err != nil {
+			return
+		}
+	}
+	// 完成后保留状态到过期  客户端可以继续查询偏移
+	return
+}
+
+func (c Config) load(ctx *gin.Context, action string) (upload *Upload, err error) {
+	id := ctx.Param("id")
+	if !bson.IsObjectIdHex(id) {
+		err = ErrNotFound
+		return
+	}
+	if upload, err = c.get(ctx, id); err != nil {
+		return
+	}
+	resource := ctx.MustGet(ginResource.CONTEXT).(*ginResource.Resource)
+	if resource.Owner == "" {
+		resource.Owner = upload.Owner
+	}
+	err = c.authorize(ctx, resource, action, upload)
+	return
+}
+
+func (c Config) get(ctx *gin.Context, id string) (upload *Upload, err error) {
+	redisClient := ctx.MustGet(redisMiddleware.CONTEXT).(*redis.Client)
+	var value string
+	if value, err = redisClient.Get(key(id)).Result(); err == redis.Nil {
+		err = ErrNotFound
+		return
+	} else if err != nil {
+		return
+	}
+	upload = &Upload{}
+	if err = json.Unmarshal([]byte(value), upload); err != nil {
+		return
+	}
+	if time.Now().After(upload.Expires) {
+		// 完成的文件保留
+		if !upload.Completed {
+			c.Store.Remove(ctx, upload)
+		}
+		redisClient.Del(key(id))
+		err = &errs.Error{
+			Message:    http.StatusText(http.StatusGone),
+			Type:       "tus",
+			StatusCode: http.StatusGone,
+		}
+	}
+	return
+}
+
+func (c Config) save(ctx *gin.Context, upload *Upload) (err error) {
+	var value []byte
+	if value, err = json.Marshal(upload); err != nil {
+		return
+	}
+	redisClient := ctx.MustGet(redisMiddleware.CONTEXT).(*redis.Client)
+	return redisClient.Set(key(upload.ID), value, time.Until(upload.Expires)).Err()
+}
+
+func (c Config) authorize(ctx *gin.Context, resource *ginResource.Resource, action string, upload *Upload) (err error) {
+	if !c.Scope {
+		return
+	}
+	if resource.Type == "" {
+		resource.Type = "upload"
+	}
+	if resource.Action == "" {
+		resource.Action = action
+	}
+	if resource.Value == "" && action != "create" {
+		resource.Value = upload.ID
+	}
+	_, err = scope.Validate(ctx)
+	return
+}
+
+func key(id string) string {
+	return PREFIX + "." + id
+}
+
+// Upload-Metadata  key base64,key2 base64
+func parseMetadata(header string) (metadata map[string]string) {
+	metadata = map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 {
+			continue
+		}
+		var value []byte
+		if len(fields) > 1 {
+			var err error
+			if value, err = base64.StdEncoding.DecodeString(fields[1]); err != nil {
+				continue
+			}
+		}
+		metadata[fields[0]] = string(value)
+	}
+	return
+}
+
+func formatMetadata(metadata map[string]string) string {
+	var pairs []string
+	for name, value := range metadata {
+		if value == "" {
+			pairs = append(pairs, name)
+		} else {
+			pairs = append(pairs, name+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+		}
+	}
+	return strings.Join(pairs, ",")
+}
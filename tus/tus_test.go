@@ -0,0 +1,214 @@
+package tus
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis"
+	"github.com/otamoe/gin-server/errs"
+	redisMiddleware "github.com/otamoe/gin-server/redis"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+type (
+	memoryStore struct {
+		mutex    sync.Mutex
+		data     map[string][]byte
+		finished int
+	}
+)
+
+func (store *memoryStore) Create(ctx *gin.Context, upload *Upload) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.data[upload.ID] = nil
+	return nil
+}
+
+func (store *memoryStore) Append(ctx *gin.Context, upload *Upload, reader io.Reader) (int64, error) {
+	body, err := ioutil.ReadAll(reader)
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.data[upload.ID] = append(store.data[upload.ID][:upload.Offset], body...)
+	return int64(len(body)), err
+}
+
+func (store *memoryStore) Finish(ctx *gin.Context, upload *Upload) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.finished++
+	return nil
+}
+
+func (store *memoryStore) Remove(ctx *gin.Context, upload *Upload) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	delete(store.data, upload.ID)
+	return nil
+}
+
+// 只支持 GET SET DEL 的 redis
+func testRedis(t *testing.T) *redis.Client {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mutex sync.Mutex
+	values := map[string]string{}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					args, err := readCommand(reader)
+					if err != nil {
+						return
+					}
+					mutex.Lock()
+					reply := command(values, args)
+					mutex.Unlock()
+					if _, err = conn.Write([]byte(reply)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	client := redis.NewClient(&redis.Options{Addr: listener.Addr().String()})
+	t.Cleanup(func() {
+		client.Close()
+		listener.Close()
+	})
+	return client
+}
+
+func readCommand(reader *bufio.Reader) (args []string, err error) {
+	var line string
+	if line, err = reader.ReadString('\n'); err != nil {
+		return
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	for i := 0; i < count; i++ {
+		if line, err = reader.ReadString('\n'); err != nil {
+			return
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		arg := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, arg); err != nil {
+			return
+		}
+		args = append(args, string(arg[:size]))
+	}
+	return
+}
+
+func command(values map[string]string, args []string) string {
+	switch strings.ToLower(args[0]) {
+	case "get":
+		value, ok := values[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+	case "set":
+		for _, arg := range args[3:] {
+			if _, ok := values[args[1]]; ok && strings.EqualFold(arg, "nx") {
+				return "$-1\r\n"
+			}
+		}
+		values[args[1]] = args[2]
+		return "+OK\r\n"
+	case "del":
+		n := 0
+		for _, key := range args[1:] {
+			if _, ok := values[key]; ok {
+				delete(values, key)
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func testEngine(t *testing.T, store Store) *gin.Engine {
+	client := testRedis(t)
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Use(errs.Middleware())
+	engine.Use(func(ctx *gin.Context) {
+		ctx.Set(redisMiddleware.CONTEXT, client)
+		ctx.Set(ginResource.CONTEXT, &ginResource.Resource{})
+	})
+	Config{Store: store}.Register(engine.Group("/files"))
+	return engine
+}
+
+func request(engine *gin.Engine, method string, path string, header map[string]string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("Tus-Resumable", VERSION)
+	for key, value := range header {
+		req.Header.Set(key, value)
+	}
+	res := httptest.NewRecorder()
+	engine.ServeHTTP(res, req)
+	return res
+}
+
+// 客户端没有收到最后一次 PATCH 的回复  用相同的偏移重试
+func TestPatchRetry(t *testing.T) {
+	store := &memoryStore{data: map[string][]byte{}}
+	engine := testEngine(t, store)
+
+	res := request(engine, http.MethodPost, "/files", map[string]string{"Upload-Length": "10"}, nil)
+	if res.Code != http.StatusCreated {
+		t.Fatalf("create %d %s", res.Code, res.Body.String())
+	}
+	location := res.Header().Get("Location")
+
+	patch := func(offset int, body string) *httptest.ResponseRecorder {
+		return request(engine, http.MethodPatch, location, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(offset),
+		}, []byte(body))
+	}
+
+	if res = patch(0, "hello"); res.Code != http.StatusNoContent {
+		t.Fatalf("first %d %s", res.Code, res.Body.String())
+	}
+	for i := 0; i < 2; i++ {
+		if res = patch(5, "world"); res.Code != http.StatusNoContent {
+			t.Fatalf("final %d: %d %s", i, res.Code, res.Body.String())
+		}
+		if val := res.Header().Get("Upload-Offset"); val != "10" {
+			t.Fatalf("final %d: Upload-Offset %s", i, val)
+		}
+	}
+	if store.finished != 1 {
+		t.Fatalf("finish %d", store.finished)
+	}
+	if data := store.data[location[strings.LastIndexByte(location, '/')+1:]]; string(data) != "helloworld" {
+		t.Fatalf("%q", data)
+	}
+
+	// 长度不同的请求不是重试
+	if res = patch(4, "world"); res.Code != http.StatusConflict {
+		t.Fatalf("stale %d %s", res.Code, res.Body.String())
+	}
+}
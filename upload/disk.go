@@ -0,0 +1,55 @@
+package upload
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	Disk struct {
+		Root string
+		Mode os.FileMode
+	}
+)
+
+func (disk *Disk) Save(ctx *gin.Context, file *File, reader io.Reader) (err error) {
+	mode := disk.Mode
+	if mode == 0 {
+		mode = 0644
+	}
+
+	// 按 id 末两位分目录
+	id := file.ID.Hex()
+	file.Path = path.Join(id[len(id)-2:], id+strings.ToLower(path.Ext(file.Name)))
+
+	name := filepath.Join(disk.Root, filepath.FromSlash(file.Path))
+	if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return
+	}
+
+	var writer *os.File
+	if writer, err = os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode); err != nil {
+		return
+	}
+	if _, err = io.Copy(writer, reader); err != nil {
+		writer.Close()
+		os.Remove(name)
+		return
+	}
+	if err = writer.Close(); err != nil {
+		os.Remove(name)
+	}
+	return
+}
+
+func (disk *Disk) Remove(ctx *gin.Context, file *File) error {
+	if file.Path == "" {
+		return nil
+	}
+	return os.Remove(filepath.Join(disk.Root, filepath.FromSlash(file.Path)))
+}
@@ -0,0 +1,62 @@
+package upload
+
+import (
+	"io"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo"
+	"github.com/otamoe/gin-server/mongo"
+	ginResource "github.com/otamoe/gin-server/resource"
+)
+
+type (
+	GridFS struct {
+		Database string
+		Prefix   string
+	}
+)
+
+func (gridfs *GridFS) Get(ctx *gin.Context) *mgo.GridFS {
+	prefix := gridfs.Prefix
+	if prefix == "" {
+		prefix = "fs"
+	}
+	session := ctx.MustGet(mongo.CONTEXT).(*mgo.Session)
+	return session.DB(gridfs.Database).GridFS(prefix)
+}
+
+func (gridfs *GridFS) Save(ctx *gin.Context, file *File, reader io.Reader) (err error) {
+	var writer *mgo.GridFile
+	if writer, err = gridfs.Get(ctx).Create(file.Name); err != nil {
+		return
+	}
+	writer.SetId(file.ID)
+	writer.SetContentType(file.ContentType)
+	meta := map[string]interface{}{
+		"field": file.Field,
+	}
+	// 资源所有者 用于读取时的权限验证
+	if val, ok := ctx.Get(ginResource.CONTEXT); ok && val != nil {
+		resource := val.(*ginResource.Resource)
+		resource.Pre()
+		if resource.Owner != "" {
+			meta["owner"] = resource.Owner
+		}
+	}
+	writer.SetMeta(meta)
+	if _, err = io.Copy(writer, reader); err != nil {
+		// 写入失败 丢弃已写入的块
+		writer.Abort()
+		writer.Close()
+		return
+	}
+	if err = writer.Close(); err != nil {
+		return
+	}
+	file.Path = file.ID.Hex()
+	return
+}
+
+func (gridfs *GridFS) Remove(ctx *gin.Context, file *File) error {
+	return gridfs.Get(ctx).RemoveId(file.ID)
+}
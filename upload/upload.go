@@ -0,0 +1,312 @@
+package upload
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/globalsign/mgo/bson"
+	"github.com/otamoe/gin-server/compress"
+	"github.com/otamoe/gin-server/errs"
+	"github.com/otamoe/gin-server/size"
+)
+
+type (
+	Config struct {
+		Storage Storage
+
+		// 请求体总大小
+		MaxSize int64
+
+		// 非文件字段的最大长度
+		MaxValue int64
+
+		// 允许的文件字段  未配置的字段拒绝
+		Fields map[string]Field
+	}
+
+	Field struct {
+		MaxSize  int64
+		MaxCount int
+
+		// 允许的类型 按内容检测  如 image/*
+		Types []string
+
+		// 允许的扩展名  如 .jpg
+		Extensions []string
+	}
+
+	File struct {
+		ID          bson.ObjectId `json:"id" bson:"_id"`
+		Field       string        `json:"field" bson:"field"`
+		Name        string        `json:"name" bson:"name"`
+		ContentType string        `json:"content_type" bson:"content_type"`
+		Size        int64         `json:"size" bson:"size"`
+		Hash        string        `json:"hash" bson:"hash"`
+		Path        string        `json:"path,omitempty" bson:"path,omitempty"`
+	}
+
+	Storage interface {
+		Save(ctx *gin.Context, file *File, reader io.Reader) error
+		Remove(ctx *gin.Context, file *File) error
+	}
+
+	limitReader struct {
+		reader    io.Reader
+		remaining int64
+	}
+
+	countWriter struct {
+		n int64
+	}
+)
+
+var CONTEXT = "GIN.SERVER.UPLOAD"
+var CONTEXT_VALUES = "GIN.SERVER.UPLOAD.VALUES"
+
+var errTooLarge = errors.New(http.StatusText(http.StatusRequestEntityTooLarge))
+
+func Middleware(c Config) gin.HandlerFunc {
+	if c.MaxValue == 0 {
+		c.MaxValue = 1024 * 64
+	}
+	return func(ctx *gin.Context) {
+		var err error
+		var files []*File
+		values := url.Values{}
+		defer func() {
+			if err == nil {
+				return
+			}
+			for _, file := range files {
+				c.Storage.Remove(ctx, file)
+			}
+			ctx.Error(err)
+			ctx.Abort()
+		}()
+
+		// 替换 size.Middleware 的限制
+		if reader, ok := ctx.Request.Body.(*size.Reader); ok && c.MaxSize != 0 {
+			reader.Remaining = c.MaxSize
+		}
+
+		reader, e := ctx.Request.MultipartReader()
+		if e != nil {
+			err = &errs.Error{
+				Err:        e,
+				Type:       "upload",
+				StatusCode: http.StatusBadRequest,
+			}
+			return
+		}
+
+		counts := map[string]int{}
+		for {
+			part, e := reader.NextPart()
+			if e == io.EOF {
+				break
+			}
+			if e != nil {
+				err = bodyError(e)
+				return
+			}
+
+			name := part.FormName()
+			if name == "" {
+				part.Close()
+				continue
+			}
+
+			// 普通字段
+			if part.FileName() == "" {
+				var buf bytes.Buffer
+				if _, e = io.Copy(&buf, &limitReader{reader: part, remaining: c.MaxValue}); e != nil {
+					part.Close()
+					err = fieldError(name, e)
+					return
+				}
+				part.Close()
+				values.Add(name, buf.String())
+				continue
+			}
+
+			var file *File
+			file, err = c.save(ctx, part, counts)
+			part.Close()
+			if file != nil {
+				files = append(files, file)
+			}
+			if err != nil {
+				return
+			}
+		}
+
+		ctx.Set(CONTEXT, files)
+		ctx.Set(CONTEXT_VALUES, values)
+		ctx.Next()
+	}
+}
+
+func (c Config) save(ctx *gin.Context, part *multipart.Part, counts map[string]int) (file *File, err error) {
+	name := part.FormName()
+	filename := path.Base(strings.Replace(part.FileName(), "\\", "/", -1))
+
+	field, ok := c.Fields[name]
+	if !ok {
+		err = &errs.Error{
+			Message:    "Unknown upload field",
+			Type:       "upload",
+			Path:       name,
+			StatusCode: http.StatusBadRequest,
+		}
+		return
+	}
+
+	maxCount := field.MaxCount
+	if maxCount == 0 {
+		maxCount = 1
+	}
+	if counts[name]++; counts[name] > maxCount {
+		err = &errs.Error{
+			Message:    "Too many files",
+			Type:       "upload",
+			Path:       name,
+			StatusCode: http.StatusBadRequest,
+			Params: map[string]interface{}{
+				"max": maxCount,
+			},
+		}
+		return
+	}
+
+	// 扩展名
+	ext := strings.ToLower(path.Ext(filename))
+	if len(field.Extensions) != 0 {
+		var match bool
+		for _, val := range field.Extensions {
+			if strings.ToLower(val) == ext {
+				match = true
+				break
+			}
+		}
+		if !match {
+			err = &errs.Error{
+				Message:    "File extension is not allowed",
+				Type:       "upload",
+				Path:       name,
+				Value:      ext,
+				StatusCode: http.StatusUnsupportedMediaType,
+			}
+			return
+		}
+	}
+
+	// 内容类型检测
+	head := make([]byte, 512)
+	n, e := io.ReadFull(part, head)
+	if e != nil && e != io.EOF && e != io.ErrUnexpectedEOF {
+		err = bodyError(e)
+		return
+	}
+	head = head[:n]
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if len(field.Types) != 0 && !compress.MatchType(field.Types, contentType) {
+		err = &errs.Error{
+			Message:    "File type is not allowed",
+			Type:       "upload",
+			Path:       name,
+			Value:      contentType,
+			StatusCode: http.StatusUnsupportedMediaType,
+		}
+		return
+	}
+
+	maxSize := field.MaxSize
+	if maxSize == 0 {
+		maxSize = c.MaxSize
+	}
+
+	file = &File{
+		ID:          bson.NewObjectId(),
+		Field:       name,
+		Name:        filename,
+		ContentType: contentType,
+	}
+
+	hash := sha256.New()
+	var reader io.Reader = io.MultiReader(bytes.NewReader(head), part)
+	if maxSize > 0 {
+		reader = &limitReader{reader: reader, remaining: maxSize}
+	}
+	counter := &countWriter{}
+	reader = io.TeeReader(reader, io.MultiWriter(hash, counter))
+
+	if e := c.Storage.Save(ctx, file, reader); e != nil {
+		err = fieldError(name, e)
+		return
+	}
+	file.Size = counter.n
+	file.Hash = hex.EncodeToString(hash.Sum(nil))
+	return
+}
+
+func (r *limitReader) Read(p []byte) (n int, err error) {
+	if r.remaining <= 0 {
+		// 是否还有数据
+		var buf [1]byte
+		if n, _ = r.reader.Read(buf[:]); n > 0 {
+			return 0, errTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err = r.reader.Read(p)
+	r.remaining -= int64(n)
+	return
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func fieldError(name string, err error) error {
+	if errors.Is(err, errTooLarge) {
+		return &errs.Error{
+			Message:    err.Error(),
+			Type:       "upload",
+			Path:       name,
+			StatusCode: http.StatusRequestEntityTooLarge,
+		}
+	}
+	return bodyError(err)
+}
+
+func bodyError(err error) error {
+	if e, ok := err.(*errs.Error); ok {
+		return e
+	}
+	if errors.Is(err, size.ErrTooLarge) {
+		return &errs.Error{
+			Message:    err.Error(),
+			Type:       "upload",
+			StatusCode: http.StatusRequestEntityTooLarge,
+		}
+	}
+	return &errs.Error{
+		Err:        err,
+		Type:       "upload",
+		StatusCode: http.StatusBadRequest,
+	}
+}